@@ -6,12 +6,25 @@ import (
 
 var Commands = []cli.Command{
 	initCommand,
+	monitorCommand,
+	daemonCommand,
 	runCommand,
 	listCommand,
+	inspectCommand,
+	statsCommand,
+	topCommand,
+	eventsCommand,
+	metricsCommand,
 	logCommand,
 	execCommand,
+	startCommand,
 	stopCommand,
+	updateCommand,
 	removeCommand,
+	commitCommand,
+	exportCommand,
 	networkCommand,
 	imageCommand,
+	trustCommand,
+	ociCommand,
 }
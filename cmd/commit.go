@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"github.com/yqszxx/oreo-box/pkg/oreobox"
+)
+
+var commitCommand = cli.Command{
+	Name:      "commit",
+	Usage:     "Create an image from the changes made in a box",
+	ArgsUsage: "BOX IMAGE",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "m",
+			Usage: "comment recorded in the image history",
+		},
+		cli.StringFlag{
+			Name:  "sign-key",
+			Usage: "armored private key to sign the image with, its public key must be trusted",
+		},
+	},
+	Action: commitHandler,
+}
+
+func commitHandler(context *cli.Context) error {
+	if len(context.Args()) < 2 {
+		return fmt.Errorf("no enough arguments provided")
+	}
+	metadata, err := oreobox.New().Commit(context.Args().Get(0), context.Args().Get(1), &oreobox.CommitOptions{
+		Comment:     context.String("m"),
+		SignKeyPath: context.String("sign-key"),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(metadata.Digest)
+	return nil
+}
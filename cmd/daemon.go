@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"github.com/yqszxx/oreo-box/config"
+	"github.com/yqszxx/oreo-box/internal/api"
+	"github.com/yqszxx/oreo-box/internal/network"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var daemonCommand = cli.Command{
+	Name:  "daemon",
+	Usage: "Serve the API, other commands become clients of the daemon while it is up",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "socket",
+			Usage: "unix socket to listen on",
+			Value: config.DaemonSocketPath,
+		},
+	},
+	Action: daemonHandler,
+}
+
+func daemonHandler(context *cli.Context) error {
+	socketPath := context.String("socket")
+
+	if err := network.Init(); err != nil {
+		return fmt.Errorf("cannot init network controller: %v", err)
+	}
+
+	// a socket left behind by a daemon which was killed
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove stale socket `%s`: %v", socketPath, err)
+	}
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("cannot listen on `%s`: %v", socketPath, err)
+	}
+	// the API is as powerful as root, keep it to root
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		_ = listener.Close()
+		return fmt.Errorf("cannot set permissions of `%s`: %v", socketPath, err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		sig := <-signals
+		log.Printf("received %v, shutting down", sig)
+		close(stopped)
+		// closing the listener removes the socket file
+		if err := listener.Close(); err != nil {
+			log.Printf("cannot close listener: %v", err)
+		}
+	}()
+
+	log.Printf("serving API %s on %s", api.Version, socketPath)
+	err = api.NewServer().Serve(listener)
+	select {
+	case <-stopped:
+		return nil
+	default:
+		return fmt.Errorf("cannot serve API: %v", err)
+	}
+}
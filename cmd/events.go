@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli"
+	"github.com/yqszxx/oreo-box/internal/events"
+	"strconv"
+	"time"
+)
+
+var eventsCommand = cli.Command{
+	Name:  "events",
+	Usage: "Show lifecycle events of boxes, images and networks",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "since",
+			Usage: "show events after a RFC3339 time, unix timestamp or duration ago like 10m",
+		},
+		cli.StringFlag{
+			Name:  "until",
+			Usage: "stop at a RFC3339 time, unix timestamp or duration ago like 10m, follow new events if not set",
+		},
+		cli.StringSliceFlag{
+			Name:  "filter",
+			Usage: "only show matching events, e.g. type=box, action=die, name=web",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "output format, human or json",
+			Value: "human",
+		},
+	},
+	Action: eventsHandler,
+}
+
+func eventsHandler(context *cli.Context) error {
+	format := context.String("format")
+	if format != "human" && format != "json" {
+		return fmt.Errorf("unknown format `%s`", format)
+	}
+
+	var since, until time.Time
+	var err error
+	if context.String("since") != "" {
+		if since, err = parseEventTime(context.String("since")); err != nil {
+			return err
+		}
+	}
+	if context.String("until") != "" {
+		if until, err = parseEventTime(context.String("until")); err != nil {
+			return err
+		}
+	}
+
+	filter, err := events.ParseFilter(context.StringSlice("filter"))
+	if err != nil {
+		return err
+	}
+
+	return events.Follow(since, until, filter, func(event *events.Event) error {
+		if format == "json" {
+			eventBytes, err := json.Marshal(event)
+			if err != nil {
+				return fmt.Errorf("cannot marshal event: %v", err)
+			}
+			fmt.Println(string(eventBytes))
+			return nil
+		}
+		fmt.Println(event)
+		return nil
+	})
+}
+
+// parseEventTime accepts a RFC3339 time, a unix timestamp or a duration before now
+func parseEventTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-duration), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time `%s`", value)
+}
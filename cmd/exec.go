@@ -4,11 +4,9 @@ import (
 	"fmt"
 	"github.com/urfave/cli"
 	"github.com/yqszxx/oreo-box/config"
-	"github.com/yqszxx/oreo-box/internal"
+	"github.com/yqszxx/oreo-box/pkg/oreobox"
 	"log"
 	"os"
-	"os/exec"
-	"strings"
 )
 
 var execCommand = cli.Command{
@@ -33,35 +31,5 @@ func execHandler(context *cli.Context) error {
 		commandArray = append(commandArray, arg)
 	}
 
-	pid, err := internal.GetBoxPidByName(boxName)
-	if err != nil {
-		return fmt.Errorf("getBoxPidByName(%s) failed with error: %v", boxName, err)
-	}
-
-	cmdStr := strings.Join(commandArray, " ")
-	log.Printf("exec in box pid %s with command '%s'\n", pid, cmdStr)
-
-	cmd := exec.Command("/proc/self/exe", "exec")
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := os.Setenv(config.EnvExecPid, pid); err != nil {
-		return err
-	}
-	if err := os.Setenv(config.EnvExecPid, cmdStr); err != nil {
-		return err
-	}
-
-	boxEnvs, err := internal.GetEnvsByPid(pid)
-	if err != nil {
-		return err
-	}
-	cmd.Env = append(os.Environ(), boxEnvs...)
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("cannot exec in box %s: %v", boxName, err)
-	}
-
-	return nil
+	return oreobox.New().Exec(boxName, commandArray, os.Stdin, os.Stdout, os.Stderr)
 }
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"github.com/yqszxx/oreo-box/pkg/oreobox"
+	"io"
+	"os"
+)
+
+var exportCommand = cli.Command{
+	Name:      "export",
+	Usage:     "Export the filesystem of a box as a tarball",
+	ArgsUsage: "BOX",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "o",
+			Usage: "write to a file instead of stdout",
+		},
+	},
+	Action: exportHandler,
+}
+
+func exportHandler(context *cli.Context) error {
+	if len(context.Args()) < 1 {
+		return fmt.Errorf("cannot find box name")
+	}
+	boxName := context.Args().Get(0)
+	return writeOutput(context.String("o"), func(w io.Writer) error {
+		return oreobox.New().Export(boxName, w)
+	})
+}
+
+// writeOutput lets `write` write to the file at `outputPath`, or stdout if it is empty,
+// the file is removed if `write` fails
+func writeOutput(outputPath string, write func(io.Writer) error) error {
+	if outputPath == "" {
+		return write(os.Stdout)
+	}
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("cannot create `%s`: %v", outputPath, err)
+	}
+	err = write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(outputPath)
+		return err
+	}
+	return nil
+}
@@ -1,9 +1,16 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/urfave/cli"
-	"github.com/yqszxx/oreo-box/internal/image"
+	"github.com/yqszxx/oreo-box/internal/cgroup/subsystems"
+	"github.com/yqszxx/oreo-box/pkg/oreobox"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
 )
 
 var imageCommand = cli.Command{
@@ -11,41 +18,250 @@ var imageCommand = cli.Command{
 	Usage: "Manage images",
 	Subcommands: []cli.Command{
 		{
-			Name:  "import",
-			Usage: "Import an image",
+			Name:      "import",
+			Usage:     "Import an image",
+			ArgsUsage: "NAME ARCHIVE (a signed image, or an OCI layout or docker save archive signed at ARCHIVE.asc)",
 			Flags: []cli.Flag{
 				cli.BoolFlag{
 					Name:  "f",
-					Usage: "force import",
+					Usage: "replace an existing image of the same name, and move the given tags from other images",
+				},
+				cli.StringSliceFlag{
+					Name:  "tag",
+					Usage: "further name of the image, can be repeated",
+				},
+				cli.BoolFlag{
+					Name:  "insecure-skip-verify",
+					Usage: "import without checking the signature, for development only",
+				},
+				cli.BoolFlag{
+					Name:  "keep-archive",
+					Usage: "keep the signed image data so that save needs no signing key, which stores the image twice",
 				},
 			},
 			Action: func(context *cli.Context) error {
 				if len(context.Args()) < 2 {
 					return fmt.Errorf("no enough arguments provided")
 				}
+				imageName := context.Args().Get(0)
 
-				return image.Import(context.Args().Get(0), context.Args().Get(1))
+				if err := oreobox.New().ImportImage(imageName, context.Args().Get(1), &oreobox.ImportOptions{
+					InsecureSkipVerify: context.Bool("insecure-skip-verify"),
+					Force:              context.Bool("f"),
+					Tags:               context.StringSlice("tag"),
+					KeepArchive:        context.Bool("keep-archive"),
+				}); err != nil {
+					return err
+				}
+				fmt.Println(imageName)
+				return nil
 			},
 		},
 		{
-			Name:  "list",
-			Usage: "List images",
+			Name:      "list",
+			Usage:     "List images",
+			ArgsUsage: "[REFERENCE...] (name, name:tag or digest)",
 			Action: func(context *cli.Context) error {
-				return image.List()
+				images, err := oreobox.New().Images(context.Args()...)
+				if err != nil {
+					return err
+				}
+				return printImages(images)
 			},
 		},
 		{
-			Name:  "remove",
-			Usage: "Remove an image",
+			Name:      "inspect",
+			Usage:     "Show details of an image",
+			ArgsUsage: "REFERENCE (name, name:tag or digest)",
 			Action: func(context *cli.Context) error {
 				if len(context.Args()) < 1 {
 					return fmt.Errorf("no image name provided")
 				}
-
-				//TODO: need to check whether image is being used
-
-				return fmt.Errorf("not implemented")
+				reference := context.Args().Get(0)
+				detail, err := oreobox.New().InspectImage(reference)
+				if err != nil {
+					return err
+				}
+				detailBytes, err := json.MarshalIndent(detail, "", "    ")
+				if err != nil {
+					return fmt.Errorf("fail to serilize details of `%s`: %v", reference, err)
+				}
+				fmt.Println(string(detailBytes))
+				return nil
+			},
+		},
+		{
+			Name:      "history",
+			Usage:     "Show how a layered image was built, latest step first",
+			ArgsUsage: "REFERENCE (name, name:tag or digest)",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("no image name provided")
+				}
+				entries, err := oreobox.New().ImageHistory(context.Args().Get(0))
+				if err != nil {
+					return err
+				}
+				return printImageHistory(entries)
+			},
+		},
+		{
+			Name:      "save",
+			Usage:     "Save an image in the signed format import accepts",
+			ArgsUsage: "NAME",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "o",
+					Usage: "write to a file instead of stdout",
+				},
+				cli.StringFlag{
+					Name:  "sign-key",
+					Usage: "armored private key to sign the image with, required unless it was committed signed or imported signed with --keep-archive",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("no image name provided")
+				}
+				imageName := context.Args().Get(0)
+				return writeOutput(context.String("o"), func(w io.Writer) error {
+					return oreobox.New().SaveImage(imageName, w, context.String("sign-key"))
+				})
+			},
+		},
+		{
+			Name:      "tag",
+			Usage:     "Add a tag to an image",
+			ArgsUsage: "REFERENCE NAME[:TAG] (the tag defaults to latest)",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "f",
+					Usage: "move the tag if it refers to another image",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 2 {
+					return fmt.Errorf("no enough arguments provided")
+				}
+				return oreobox.New().TagImage(context.Args().Get(0), context.Args().Get(1), context.Bool("f"))
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "Remove image layers no image references",
+			Action: func(context *cli.Context) error {
+				report, err := oreobox.New().PruneImages()
+				if err != nil {
+					return err
+				}
+				for _, layer := range report.Layers {
+					fmt.Printf("deleted: %s\n", layer)
+				}
+				fmt.Printf("Total reclaimed space: %d bytes\n", report.SpaceReclaimed)
+				return nil
+			},
+		},
+		{
+			Name:      "remove",
+			Usage:     "Remove images, or tags which leaves the image",
+			ArgsUsage: "REFERENCE [REFERENCE...] (name, name:tag or digest)",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "force, f",
+					Usage: "remove even if boxes use the image",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("no image name provided")
+				}
+				client := oreobox.New()
+				failed := false
+				for _, imageName := range context.Args() {
+					if err := client.RemoveImage(imageName, context.Bool("force")); err != nil {
+						log.Printf("Cannot remove image `%s`: %v", imageName, err)
+						failed = true
+						continue
+					}
+					fmt.Println(imageName)
+				}
+				if failed {
+					return fmt.Errorf("some images were not removed")
+				}
+				return nil
 			},
 		},
 	},
 }
+
+func printImages(images []*oreobox.Image) error {
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	if _, err := fmt.Fprint(w, "NAME\tTAGS\tDIGEST\tSIZE\tIMPORTED\tSIGNER\tBOXES\n"); err != nil {
+		return fmt.Errorf("fail to exec fmt.Fprint : %v", err)
+	}
+	for _, item := range images {
+		tags, digest, signer := "-", "-", "-"
+		if len(item.Tags) > 0 {
+			tags = strings.Join(item.Tags, ",")
+		}
+		if item.Metadata != nil {
+			digest = shortDigest(item.Metadata.Digest)
+			if item.Metadata.Signer != "" {
+				signer = item.Metadata.Signer
+			}
+		}
+		_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\n",
+			item.Name,
+			tags,
+			digest,
+			subsystems.HumanSize(uint64(item.Size)),
+			item.Imported.Local().Format("2006-01-02 15:04:05"),
+			signer,
+			len(item.Boxes))
+		if err != nil {
+			return fmt.Errorf("fail to exec fmt.Fprintf %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("cannot flush : %v", err)
+	}
+	return nil
+}
+
+func printImageHistory(entries []*oreobox.ImageHistoryEntry) error {
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	if _, err := fmt.Fprint(w, "LAYER\tCREATED\tCREATED BY\tSIZE\tCOMMENT\n"); err != nil {
+		return fmt.Errorf("fail to exec fmt.Fprint : %v", err)
+	}
+	for _, item := range entries {
+		layer, created := "-", "-"
+		if item.Layer != "" {
+			layer = shortDigest(item.Layer)
+		}
+		if !item.Created.IsZero() {
+			created = item.Created.Local().Format("2006-01-02 15:04:05")
+		}
+		_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			layer,
+			created,
+			item.CreatedBy,
+			subsystems.HumanSize(uint64(item.Size)),
+			item.Comment)
+		if err != nil {
+			return fmt.Errorf("fail to exec fmt.Fprintf %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("cannot flush : %v", err)
+	}
+	return nil
+}
+
+// shortDigest is the first 12 characters of the hex part of a digest, enough to refer to an image
+func shortDigest(digest string) string {
+	hex := strings.TrimPrefix(digest, "sha256:")
+	if len(hex) > 12 {
+		return hex[:12]
+	}
+	return hex
+}
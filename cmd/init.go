@@ -1,13 +1,18 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/urfave/cli"
+	"github.com/yqszxx/oreo-box/internal"
+	"github.com/yqszxx/oreo-box/internal/cgroup/subsystems"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 )
@@ -20,19 +25,63 @@ var initCommand = cli.Command{
 }
 
 func initHandler(*cli.Context) error {
-	log.Println("Starting init process...")
-
-	cmdArray, err := readUserCommand()
+	initConfig, err := readInitConfig()
 	if err != nil {
-		return fmt.Errorf("cannot read init command: %v", err)
+		return fmt.Errorf("cannot read init config: %v", err)
+	}
+	if initConfig.Silent {
+		log.SetOutput(ioutil.Discard)
 	}
+	log.Println("Starting init process...")
+
+	cmdArray := initConfig.Args
 	if cmdArray == nil || len(cmdArray) == 0 {
 		return fmt.Errorf("run box get user command error, cmdArray is nil")
 	}
 
-	if err := setUpMount(); err != nil {
+	if initConfig.Rootfs != "" {
+		if err := setUpBundleMounts(initConfig); err != nil {
+			return fmt.Errorf("cannot set up mount points: %v", err)
+		}
+	} else if err := setUpMount(); err != nil {
 		return fmt.Errorf("cannot set up mount points: %v", err)
 	}
+	if err := createDevices(initConfig.Devices); err != nil {
+		return fmt.Errorf("cannot create devices: %v", err)
+	}
+	if initConfig.Hostname != "" {
+		if err := syscall.Sethostname([]byte(initConfig.Hostname)); err != nil {
+			return fmt.Errorf("cannot set hostname: %v", err)
+		}
+	}
+	if initConfig.ReadonlyRoot {
+		if err := syscall.Mount("", "/", "", syscall.MS_REMOUNT|syscall.MS_BIND|syscall.MS_RDONLY, ""); err != nil {
+			return fmt.Errorf("cannot remount root read-only: %v", err)
+		}
+	}
+	if initConfig.WaitStart {
+		if err := waitStart(); err != nil {
+			return err
+		}
+	}
+	if initConfig.Cwd != "" {
+		if err := os.MkdirAll(initConfig.Cwd, 0755); err != nil {
+			return fmt.Errorf("cannot create working dir `%s`: %v", initConfig.Cwd, err)
+		}
+		if err := os.Chdir(initConfig.Cwd); err != nil {
+			return fmt.Errorf("cannot change dir to `%s`: %v", initConfig.Cwd, err)
+		}
+	}
+	if initConfig.User != "" {
+		if err := lookupUser(initConfig); err != nil {
+			return err
+		}
+	}
+	if initConfig.Rootfs != "" || initConfig.User != "" {
+		if err := setUser(initConfig); err != nil {
+			return err
+		}
+	}
 	path, err := exec.LookPath(cmdArray[0])
 	if err != nil {
 		return fmt.Errorf("fail to search for executable '%s' in the path dirs: %v", cmdArray[0], err)
@@ -44,7 +93,7 @@ func initHandler(*cli.Context) error {
 	return nil
 }
 
-func readUserCommand() ([]string, error) {
+func readInitConfig() (*internal.InitConfig, error) {
 	pipe := os.NewFile(uintptr(3), "pipe")
 	defer func() {
 		if err := pipe.Close(); err != nil {
@@ -55,8 +104,11 @@ func readUserCommand() ([]string, error) {
 	if err != nil {
 		return nil, fmt.Errorf("init read pipe error : %v", err)
 	}
-	msgStr := string(msg)
-	return strings.Split(msgStr, " "), nil
+	var initConfig internal.InitConfig
+	if err := json.Unmarshal(msg, &initConfig); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal init config: %v", err)
+	}
+	return &initConfig, nil
 }
 
 func setUpMount() error {
@@ -80,6 +132,241 @@ func setUpMount() error {
 	return nil
 }
 
+// setUpBundleMounts mounts the root and the mounts of an OCI bundle, then pivots into the root
+func setUpBundleMounts(initConfig *internal.InitConfig) error {
+	rootfs := initConfig.Rootfs
+	// keep mounts from propagating back to the host
+	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("cannot remount rootfs as private: %v", err)
+	}
+	// pivot_root needs the new root to be a mount point
+	if err := syscall.Mount(rootfs, rootfs, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("cannot bind mount root `%s`: %v", rootfs, err)
+	}
+
+	for _, m := range initConfig.Mounts {
+		dest := filepath.Join(rootfs, m.Destination)
+		if m.Flags&syscall.MS_BIND != 0 {
+			if err := createMountPoint(m.Source, dest); err != nil {
+				return err
+			}
+			if err := syscall.Mount(m.Source, dest, "", m.Flags&(syscall.MS_BIND|syscall.MS_REC), ""); err != nil {
+				return fmt.Errorf("cannot bind mount `%s` to `%s`: %v", m.Source, m.Destination, err)
+			}
+			// the other flags of a bind mount only take effect on a remount
+			if m.Flags&^(syscall.MS_BIND|syscall.MS_REC) != 0 {
+				if err := syscall.Mount("", dest, "", m.Flags|syscall.MS_REMOUNT, ""); err != nil {
+					return fmt.Errorf("cannot remount `%s`: %v", m.Destination, err)
+				}
+			}
+		} else {
+			if err := os.MkdirAll(dest, 0755); err != nil {
+				return fmt.Errorf("cannot create mount point `%s`: %v", m.Destination, err)
+			}
+			if err := syscall.Mount(m.Source, dest, m.Type, m.Flags, m.Data); err != nil {
+				return fmt.Errorf("cannot mount %s `%s` to `%s`: %v", m.Type, m.Source, m.Destination, err)
+			}
+		}
+		for _, flag := range m.PropagationFlags {
+			if err := syscall.Mount("", dest, "", flag, ""); err != nil {
+				return fmt.Errorf("cannot change propagation of `%s`: %v", m.Destination, err)
+			}
+		}
+	}
+
+	if err := pivotRoot(rootfs); err != nil {
+		return fmt.Errorf("cannot pivot root: %v", err)
+	}
+	return nil
+}
+
+// createMountPoint creates a dir, or an empty file if `source` is not a dir, to bind mount `source` onto
+func createMountPoint(source, dest string) error {
+	stat, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("cannot stat mount source `%s`: %v", source, err)
+	}
+	if stat.IsDir() {
+		return os.MkdirAll(dest, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(dest, os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot create mount point `%s`: %v", dest, err)
+	}
+	return file.Close()
+}
+
+// waitStart blocks until the box is started, by reading the exec fifo passed as fd 4
+func waitStart() error {
+	fifo := os.NewFile(4, "exec.fifo")
+	// `start` writes a byte
+	if _, err := io.ReadFull(fifo, make([]byte, 1)); err != nil {
+		return fmt.Errorf("cannot read exec fifo: %v", err)
+	}
+	return fifo.Close()
+}
+
+func setUser(initConfig *internal.InitConfig) error {
+	var groups []int
+	for _, gid := range initConfig.AdditionalGids {
+		groups = append(groups, int(gid))
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("cannot set supplementary groups: %v", err)
+	}
+	if err := syscall.Setgid(int(initConfig.Gid)); err != nil {
+		return fmt.Errorf("cannot set gid %d: %v", initConfig.Gid, err)
+	}
+	if err := syscall.Setuid(int(initConfig.Uid)); err != nil {
+		return fmt.Errorf("cannot set uid %d: %v", initConfig.Uid, err)
+	}
+	return nil
+}
+
+// lookupUser resolves `User` of the init config, which is `user[:group]` by name or id, into ids
+func lookupUser(initConfig *internal.InitConfig) error {
+	parts := strings.SplitN(initConfig.User, ":", 2)
+	passwd, err := readIdFile("/etc/passwd")
+	if err != nil {
+		return err
+	}
+	groups, err := readIdFile("/etc/group")
+	if err != nil {
+		return err
+	}
+
+	userName := ""
+	if uid, err := strconv.ParseUint(parts[0], 10, 32); err == nil {
+		initConfig.Uid = uint32(uid)
+		initConfig.Gid = 0
+		for _, entry := range passwd {
+			if entry[2] == parts[0] {
+				userName = entry[0]
+				if gid, err := strconv.ParseUint(entry[3], 10, 32); err == nil {
+					initConfig.Gid = uint32(gid)
+				}
+				break
+			}
+		}
+	} else {
+		found := false
+		for _, entry := range passwd {
+			if entry[0] == parts[0] {
+				uid, err := strconv.ParseUint(entry[2], 10, 32)
+				if err != nil {
+					return fmt.Errorf("invalid uid of user `%s`: %v", parts[0], err)
+				}
+				gid, err := strconv.ParseUint(entry[3], 10, 32)
+				if err != nil {
+					return fmt.Errorf("invalid gid of user `%s`: %v", parts[0], err)
+				}
+				initConfig.Uid, initConfig.Gid, userName, found = uint32(uid), uint32(gid), entry[0], true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("cannot find user `%s` in /etc/passwd", parts[0])
+		}
+	}
+
+	if len(parts) == 2 {
+		if gid, err := strconv.ParseUint(parts[1], 10, 32); err == nil {
+			initConfig.Gid = uint32(gid)
+		} else {
+			found := false
+			for _, entry := range groups {
+				if entry[0] == parts[1] {
+					gid, err := strconv.ParseUint(entry[2], 10, 32)
+					if err != nil {
+						return fmt.Errorf("invalid gid of group `%s`: %v", parts[1], err)
+					}
+					initConfig.Gid, found = uint32(gid), true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("cannot find group `%s` in /etc/group", parts[1])
+			}
+		}
+	}
+
+	// the groups listing the user as a member become supplementary groups
+	initConfig.AdditionalGids = nil
+	if userName != "" {
+		for _, entry := range groups {
+			for _, member := range strings.Split(entry[3], ",") {
+				if member != userName {
+					continue
+				}
+				if gid, err := strconv.ParseUint(entry[2], 10, 32); err == nil {
+					initConfig.AdditionalGids = append(initConfig.AdditionalGids, uint32(gid))
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// readIdFile reads the entries of /etc/passwd or /etc/group, a missing file has no entries
+func readIdFile(filePath string) ([][]string, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read `%s`: %v", filePath, err)
+	}
+	var entries [][]string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		// both files have at least 4 fields, the 4th of /etc/group lists the members
+		if len(fields) < 4 {
+			continue
+		}
+		entries = append(entries, fields)
+	}
+	return entries, nil
+}
+
+// createDevices creates device nodes in the freshly mounted `/dev`
+func createDevices(devices []*subsystems.Device) error {
+	oldMask := syscall.Umask(0)
+	defer syscall.Umask(oldMask)
+
+	for _, device := range devices {
+		if err := os.MkdirAll(filepath.Dir(device.Path), 0755); err != nil {
+			return fmt.Errorf("cannot create parent dir of `%s`: %v", device.Path, err)
+		}
+		mode := uint32(device.FileMode.Perm())
+		switch device.Type {
+		case subsystems.CharDevice:
+			mode |= syscall.S_IFCHR
+		case subsystems.BlockDevice:
+			mode |= syscall.S_IFBLK
+		default:
+			return fmt.Errorf("invalid type `%s` of device `%s`", device.Type, device.Path)
+		}
+		// the `/dev` of a bundle may not be a fresh tmpfs
+		if internal.Exist(device.Path, false) {
+			continue
+		}
+		if err := syscall.Mknod(device.Path, mode, subsystems.Mkdev(device.Major, device.Minor)); err != nil {
+			return fmt.Errorf("cannot create device `%s`: %v", device.Path, err)
+		}
+		if err := os.Chown(device.Path, int(device.Uid), int(device.Gid)); err != nil {
+			return fmt.Errorf("cannot chown device `%s`: %v", device.Path, err)
+		}
+	}
+	return nil
+}
+
 func pivotRoot(root string) error {
 	// creat directory `/.pivotDir` to store old root
 	pivotDir := filepath.Join(root, ".old_root")
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli"
+	"github.com/yqszxx/oreo-box/pkg/oreobox"
+)
+
+var inspectCommand = cli.Command{
+	Name:      "inspect",
+	Usage:     "Show details of a box, or of an image if no box has that name",
+	ArgsUsage: "BOX|IMAGE (an image by name, name:tag or digest)",
+	Action:    inspectHandler,
+}
+
+func inspectHandler(context *cli.Context) error {
+	if len(context.Args()) < 1 {
+		return fmt.Errorf("no box name provided")
+	}
+	boxName := context.Args().Get(0)
+
+	client := oreobox.New()
+	var detail interface{}
+	detail, err := client.Inspect(boxName)
+	if _, ok := err.(*oreobox.NotFoundError); ok {
+		if imageDetail, imageErr := client.InspectImage(boxName); imageErr == nil {
+			detail, err = imageDetail, nil
+		} else if _, ok := imageErr.(*oreobox.ImageNotFoundError); !ok {
+			return imageErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	detailBytes, err := json.MarshalIndent(detail, "", "    ")
+	if err != nil {
+		return fmt.Errorf("fail to serilize details of `%s`: %v", boxName, err)
+	}
+	fmt.Println(string(detailBytes))
+	return nil
+}
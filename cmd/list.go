@@ -1,46 +1,47 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/urfave/cli"
-	"github.com/yqszxx/oreo-box/config"
-	"github.com/yqszxx/oreo-box/internal"
-	"io/ioutil"
+	"github.com/yqszxx/oreo-box/pkg/oreobox"
 	"os"
-	"path"
 	"text/tabwriter"
 )
 
 var listCommand = cli.Command{
-	Name:   "ps",
-	Usage:  "list all the boxes",
+	Name:  "ps",
+	Usage: "list all the boxes",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "filter",
+			Usage: "only list matching boxes, e.g. ancestor=busybox, status=running, name=web",
+		},
+	},
 	Action: listHandler,
 }
 
-func listHandler(*cli.Context) error {
-	files, err := ioutil.ReadDir(config.BoxDataPath)
+func listHandler(context *cli.Context) error {
+	filter, err := oreobox.ParseBoxFilter(context.StringSlice("filter"))
 	if err != nil {
-		return fmt.Errorf("cannot read dir %s : %v", config.BoxDataPath, err)
+		return err
 	}
-
-	var boxes []*internal.BoxInfo
-	for _, file := range files {
-		tmpBox, err := getBoxInfo(file)
-		if err != nil {
-			return fmt.Errorf("cannot get box info : %v", err)
-		}
-		boxes = append(boxes, tmpBox)
+	boxes, err := oreobox.New().List()
+	if err != nil {
+		return fmt.Errorf("cannot get box info : %v", err)
 	}
 
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	if _, err := fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n"); err != nil {
+	if _, err := fmt.Fprint(w, "ID\tNAME\tIMAGE\tPID\tSTATUS\tCOMMAND\tCREATED\n"); err != nil {
 		return fmt.Errorf("fail to exec fmt.Fprint : %v", err)
 	}
 	for _, item := range boxes {
-		_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+		if !filter.Match(item) {
+			continue
+		}
+		_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
 			item.Id,
 			item.Name,
+			item.Image,
 			item.Pid,
 			item.Status,
 			item.Command,
@@ -54,18 +55,3 @@ func listHandler(*cli.Context) error {
 	}
 	return nil
 }
-
-func getBoxInfo(file os.FileInfo) (*internal.BoxInfo, error) {
-	boxName := file.Name()
-	configFileDir := path.Join(config.BoxDataPath, boxName, config.InfoFileName)
-	content, err := ioutil.ReadFile(configFileDir)
-	if err != nil {
-		return nil, fmt.Errorf("cannot read file %s : %v", configFileDir, err)
-	}
-	var BoxInfo internal.BoxInfo
-	if err := json.Unmarshal(content, &BoxInfo); err != nil {
-		return nil, fmt.Errorf("fail to exec json unmarshal : %v", err)
-	}
-
-	return &BoxInfo, nil
-}
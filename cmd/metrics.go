@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/urfave/cli"
+	"github.com/yqszxx/oreo-box/internal/metrics"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var metricsCommand = cli.Command{
+	Name:  "metrics",
+	Usage: "Export metrics of boxes, images and networks",
+	Subcommands: []cli.Command{
+		{
+			Name:  "serve",
+			Usage: "Serve metrics in Prometheus text format at /metrics",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "listen",
+					Usage: "tcp address like :9323, or unix socket like unix:///run/oreo-box-metrics.sock",
+					Value: ":9323",
+				},
+			},
+			Action: metricsServeHandler,
+		},
+	},
+}
+
+func metricsServeHandler(context *cli.Context) error {
+	listener, err := listen(context.String("listen"))
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		// collect into a buffer so that a failed scrape does not return partial metrics
+		buf := new(bytes.Buffer)
+		if err := metrics.Write(buf); err != nil {
+			log.Printf("cannot collect metrics: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			log.Printf("cannot write metrics: %v", err)
+		}
+	})
+
+	log.Printf("serving metrics on %s", listener.Addr())
+	return http.Serve(listener, mux)
+}
+
+// listen opens a tcp listener, or a unix socket listener for addresses starting with `unix://`
+func listen(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, "unix://") {
+		socketPath := strings.TrimPrefix(address, "unix://")
+		// remove the socket left over by a previous run
+		if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("cannot remove stale socket `%s`: %v", socketPath, err)
+		}
+		listener, err := net.Listen("unix", socketPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot listen on `%s`: %v", socketPath, err)
+		}
+		return listener, nil
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on `%s`: %v", address, err)
+	}
+	return listener, nil
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"github.com/yqszxx/oreo-box/internal"
+	"github.com/yqszxx/oreo-box/internal/cgroup"
+	"github.com/yqszxx/oreo-box/internal/events"
+	"log"
+	"strconv"
+	"time"
+)
+
+var monitorCommand = cli.Command{
+	Name:   "monitor",
+	Usage:  "Watch a detached box and record its death and OOM kills, DO NOT call directly",
+	Action: monitorHandler,
+	Hidden: true,
+}
+
+func monitorHandler(context *cli.Context) error {
+	if len(context.Args()) < 1 {
+		return fmt.Errorf("no box name provided")
+	}
+	boxName := context.Args().Get(0)
+
+	boxInfo, err := internal.GetBoxInfoByName(boxName)
+	if err != nil {
+		return fmt.Errorf("fail to get box %s info : %v", boxName, err)
+	}
+	pid, err := strconv.Atoi(boxInfo.Pid)
+	if err != nil {
+		return fmt.Errorf("fail to conver pid from string to int: %v", err)
+	}
+
+	cgroupManager := cgroup.NewCgroupManager(boxInfo.Id)
+	oomKills, _ := cgroupManager.OomKillCount()
+
+	for internal.IsAlive(pid) {
+		time.Sleep(time.Second)
+
+		count, err := cgroupManager.OomKillCount()
+		if err != nil {
+			continue
+		}
+		if count > oomKills {
+			events.Log(events.TypeBox, "oom", boxInfo.Id, boxInfo.Name, nil)
+		}
+		oomKills = count
+	}
+
+	events.Log(events.TypeBox, "die", boxInfo.Id, boxInfo.Name, nil)
+
+	// `stop` and `remove` update the info themselves
+	boxInfo, err = internal.GetBoxInfoByName(boxName)
+	if err != nil {
+		log.Printf("box `%s` is gone: %v", boxName, err)
+		return nil
+	}
+	if boxInfo.Status == internal.Running && boxInfo.Pid == strconv.Itoa(pid) {
+		boxInfo.Status = internal.Exited
+		return internal.WriteBoxInfo(boxInfo)
+	}
+	return nil
+}
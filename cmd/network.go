@@ -3,7 +3,9 @@ package cmd
 import (
 	"fmt"
 	"github.com/urfave/cli"
-	"github.com/yqszxx/oreo-box/internal/network"
+	"github.com/yqszxx/oreo-box/pkg/oreobox"
+	"os"
+	"text/tabwriter"
 )
 
 var networkCommand = cli.Command{
@@ -27,15 +29,13 @@ var networkCommand = cli.Command{
 				if len(context.Args()) < 1 {
 					return fmt.Errorf("no enough arguments provided")
 				}
-				if err := network.Init(); err != nil {
-					return fmt.Errorf("cannot init network controller: %v", err)
-				}
+				name := context.Args()[0]
 
-				err := network.CreateNetwork(context.String("driver"), context.String("subnet"), context.Args()[0])
+				err := oreobox.New().CreateNetwork(name, context.String("driver"), context.String("subnet"))
 				if err != nil {
 					return fmt.Errorf("cannot create network: %v", err)
 				}
-				fmt.Println(context.Args()[0])
+				fmt.Println(name)
 				return nil
 			},
 		},
@@ -43,13 +43,11 @@ var networkCommand = cli.Command{
 			Name:  "list",
 			Usage: "list box network",
 			Action: func(context *cli.Context) error {
-				if err := network.Init(); err != nil {
-					return fmt.Errorf("cannot init network controller: %v", err)
-				}
-				if err := network.ListNetwork(); err != nil {
+				nws, err := oreobox.New().Networks()
+				if err != nil {
 					return fmt.Errorf("cannot list networks: %v", err)
 				}
-				return nil
+				return printNetworks(nws)
 			},
 		},
 		{
@@ -59,10 +57,8 @@ var networkCommand = cli.Command{
 				if len(context.Args()) < 1 {
 					return fmt.Errorf("no network name provided")
 				}
-				if err := network.Init(); err != nil {
-					return fmt.Errorf("cannot init network controller: %v", err)
-				}
-				err := network.DeleteNetwork(context.Args()[0])
+
+				err := oreobox.New().RemoveNetwork(context.Args()[0])
 				if err != nil {
 					return fmt.Errorf("cannot remove network: %v", err)
 				}
@@ -71,3 +67,19 @@ var networkCommand = cli.Command{
 		},
 	},
 }
+
+func printNetworks(nws []*oreobox.Network) error {
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	_, _ = fmt.Fprint(w, "NAME\tIpRange\tDriver\n")
+	for _, nw := range nws {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n",
+			nw.Name,
+			nw.IpRange.String(),
+			nw.Driver,
+		)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("cannot flush %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli"
+	"github.com/yqszxx/oreo-box/config"
+	"github.com/yqszxx/oreo-box/internal/oci"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// ociCommand implements the OCI runtime command line, so that the binary installed as `oreo-box-oci`
+// can be used as the low-level runtime of other tools
+var ociCommand = cli.Command{
+	Name:  "oci",
+	Usage: "OCI runtime commands operating on bundles",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "root",
+			Usage: "dir for the state of containers",
+			Value: config.OciStatePath,
+		},
+		cli.StringFlag{
+			Name:  "log",
+			Usage: "log file, logs are discarded if not set",
+		},
+		cli.StringFlag{
+			Name:  "log-format",
+			Usage: "accepted for compatibility, logs are always text",
+		},
+	},
+	// stdout carries state JSON and the output of containers, so logs go elsewhere
+	Before: func(context *cli.Context) error {
+		logPath := context.String("log")
+		if logPath == "" {
+			log.SetOutput(ioutil.Discard)
+			return nil
+		}
+		logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("cannot open log file `%s`: %v", logPath, err)
+		}
+		log.SetOutput(logFile)
+		return nil
+	},
+	Subcommands: []cli.Command{
+		{
+			Name:      "create",
+			Usage:     "Create a container from a bundle",
+			ArgsUsage: "<container-id>",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "bundle, b",
+					Usage: "bundle dir containing config.json",
+					Value: ".",
+				},
+				cli.StringFlag{
+					Name:  "pid-file",
+					Usage: "file to write the pid of the container to",
+				},
+				cli.StringFlag{
+					Name:  "console-socket",
+					Usage: "not supported, containers cannot have a terminal",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				id, err := containerId(context)
+				if err != nil {
+					return err
+				}
+				if context.String("console-socket") != "" {
+					return fmt.Errorf("console sockets are not supported")
+				}
+				state, err := ociRuntime(context).Create(id, context.String("bundle"))
+				if err != nil {
+					return err
+				}
+				if pidFile := context.String("pid-file"); pidFile != "" {
+					if err := ioutil.WriteFile(pidFile, []byte(strconv.Itoa(state.Pid)), 0644); err != nil {
+						return fmt.Errorf("cannot write pid file `%s`: %v", pidFile, err)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "start",
+			Usage:     "Run the process of a created container",
+			ArgsUsage: "<container-id>",
+			Action: func(context *cli.Context) error {
+				id, err := containerId(context)
+				if err != nil {
+					return err
+				}
+				return ociRuntime(context).Start(id)
+			},
+		},
+		{
+			Name:      "state",
+			Usage:     "Print the state of a container as JSON",
+			ArgsUsage: "<container-id>",
+			Action: func(context *cli.Context) error {
+				id, err := containerId(context)
+				if err != nil {
+					return err
+				}
+				state, err := ociRuntime(context).State(id)
+				if err != nil {
+					return err
+				}
+				stateBytes, err := json.MarshalIndent(state, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(stateBytes))
+				return nil
+			},
+		},
+		{
+			Name:      "kill",
+			Usage:     "Send a signal to the process of a container, SIGTERM by default",
+			ArgsUsage: "<container-id> [signal]",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "all, a",
+					Usage: "accepted for compatibility, the signal goes to init which takes the box down with it",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				id, err := containerId(context)
+				if err != nil {
+					return err
+				}
+				signal := syscall.SIGTERM
+				if context.NArg() > 1 {
+					if signal, err = oci.ParseSignal(context.Args().Get(1)); err != nil {
+						return err
+					}
+				}
+				return ociRuntime(context).Kill(id, signal)
+			},
+		},
+		{
+			Name:      "delete",
+			Usage:     "Remove the state and cgroup of a stopped container",
+			ArgsUsage: "<container-id>",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "force, f",
+					Usage: "kill the container if it is running",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				id, err := containerId(context)
+				if err != nil {
+					return err
+				}
+				return ociRuntime(context).Delete(id, context.Bool("force"))
+			},
+		},
+	},
+}
+
+func ociRuntime(context *cli.Context) *oci.Runtime {
+	return &oci.Runtime{Root: context.GlobalString("root")}
+}
+
+func containerId(context *cli.Context) (string, error) {
+	if context.NArg() < 1 {
+		return "", fmt.Errorf("no container id provided")
+	}
+	return context.Args().Get(0), nil
+}
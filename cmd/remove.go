@@ -3,12 +3,7 @@ package cmd
 import (
 	"fmt"
 	"github.com/urfave/cli"
-	"github.com/yqszxx/oreo-box/config"
-	"github.com/yqszxx/oreo-box/internal"
-	"github.com/yqszxx/oreo-box/internal/fileSystem"
-	"os"
-	"path"
-	"strconv"
+	"github.com/yqszxx/oreo-box/pkg/oreobox"
 )
 
 var removeCommand = cli.Command{
@@ -23,24 +18,5 @@ func removeHandler(context *cli.Context) error {
 	}
 	boxName := context.Args().Get(0)
 
-	boxInfo, err := internal.GetBoxInfoByName(boxName)
-	if err != nil {
-		return fmt.Errorf("fail to get box %s info : %v", boxName, err)
-	}
-	pid, err := strconv.Atoi(boxInfo.Pid)
-	if err != nil {
-		return fmt.Errorf("fail to conver pid from string to int : %v", err)
-	}
-	if boxInfo.Status == internal.Running && internal.IsAlive(pid) {
-		return fmt.Errorf("couldn't remove running box")
-	}
-	if err := fileSystem.DeleteWorkSpace(boxInfo.Volume, boxName); err != nil {
-		return fmt.Errorf("cannot delete workspace of box `%s`: %v", boxName, err)
-	}
-	dataDir := path.Join(config.BoxDataPath, boxName)
-	if err := os.RemoveAll(dataDir); err != nil {
-		return fmt.Errorf("fail to remove file %s : %v", dataDir, err)
-	}
-
-	return nil
+	return oreobox.New().Remove(boxName)
 }
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"github.com/yqszxx/oreo-box/internal/cgroup/subsystems"
+)
+
+// resourceFlags are shared by `run` and `update`
+var resourceFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "m, memory",
+		Usage: "memory limit, e.g. 512MiB",
+	},
+	cli.StringFlag{
+		Name:  "memory-swap",
+		Usage: "memory plus swap limit, -1 for unlimited",
+	},
+	cli.StringFlag{
+		Name:  "memory-reservation",
+		Usage: "memory soft limit",
+	},
+	cli.Float64Flag{
+		Name:  "cpus",
+		Usage: "number of CPUs, e.g. 1.5",
+	},
+	cli.Uint64Flag{
+		Name:  "cpushare",
+		Usage: "relative CPU weight, between 2 and 262144",
+	},
+	cli.Int64Flag{
+		Name:  "cpuquota",
+		Usage: "CPU CFS quota in microseconds, -1 for unlimited",
+	},
+	cli.Uint64Flag{
+		Name:  "cpuperiod",
+		Usage: "CPU CFS period in microseconds",
+	},
+	cli.StringFlag{
+		Name:  "cpusetcpus",
+		Usage: "CPUs the box can run on, e.g. 0-3,6",
+	},
+	cli.StringFlag{
+		Name:  "cpusetmems",
+		Usage: "memory nodes the box can use, e.g. 0",
+	},
+	cli.Int64Flag{
+		Name:  "pids-limit",
+		Usage: fmt.Sprintf("maximum number of processes in the box, -1 for unlimited, new boxes get %d", subsystems.DefaultPidsLimit),
+	},
+	cli.UintFlag{
+		Name:  "blkio-weight",
+		Usage: "relative block IO weight, between 10 and 1000",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-read-bps",
+		Usage: "limit read rate from a device, e.g. /dev/sda:1mb",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-write-bps",
+		Usage: "limit write rate to a device, e.g. /dev/sda:1mb",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-read-iops",
+		Usage: "limit read operations per second from a device, e.g. /dev/sda:1000",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-write-iops",
+		Usage: "limit write operations per second to a device, e.g. /dev/sda:1000",
+	},
+}
+
+// parseResourceConfig converts the resource flags into typed values, flags which are not set stay zero
+func parseResourceConfig(context *cli.Context) (*subsystems.ResourceConfig, error) {
+	resConf := &subsystems.ResourceConfig{
+		CpuShare:    context.Uint64("cpushare"),
+		CpuQuotaUs:  context.Int64("cpuquota"),
+		CpuPeriodUs: context.Uint64("cpuperiod"),
+		CpuSetCpus:  context.String("cpusetcpus"),
+		CpuSetMems:  context.String("cpusetmems"),
+		PidsLimit:   context.Int64("pids-limit"),
+	}
+
+	var err error
+	if resConf.MemoryLimit, err = parseSizeFlag(context, "memory"); err != nil {
+		return nil, err
+	}
+	if resConf.MemoryReservation, err = parseSizeFlag(context, "memory-reservation"); err != nil {
+		return nil, err
+	}
+	if context.String("memory-swap") == "-1" {
+		resConf.MemorySwap = -1
+	} else if resConf.MemorySwap, err = parseSizeFlag(context, "memory-swap"); err != nil {
+		return nil, err
+	}
+
+	if context.IsSet("cpus") {
+		if context.IsSet("cpuquota") || context.IsSet("cpuperiod") {
+			return nil, fmt.Errorf("--cpus cannot be used together with --cpuquota or --cpuperiod")
+		}
+		cpus := context.Float64("cpus")
+		online, err := subsystems.OnlineCpus()
+		if err != nil {
+			return nil, err
+		}
+		if cpus <= 0 || cpus > float64(len(online)) {
+			return nil, fmt.Errorf("--cpus %v out of range (0, %d]", cpus, len(online))
+		}
+		resConf.CpuPeriodUs = subsystems.DefaultCpuPeriodUs
+		resConf.CpuQuotaUs = int64(cpus * subsystems.DefaultCpuPeriodUs)
+	}
+
+	if err := parseBlkio(context, resConf); err != nil {
+		return nil, err
+	}
+	return resConf, nil
+}
+
+func parseSizeFlag(context *cli.Context, name string) (int64, error) {
+	value := context.String(name)
+	if value == "" {
+		return 0, nil
+	}
+	size, err := subsystems.ParseBytes(value)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse --%s: %v", name, err)
+	}
+	return size, nil
+}
+
+// parseBlkio fills block IO weight and throttles of `resConf`
+func parseBlkio(context *cli.Context, resConf *subsystems.ResourceConfig) error {
+	if weight := context.Uint("blkio-weight"); weight != 0 {
+		if weight > subsystems.MaxBlkioWeight {
+			return fmt.Errorf("blkio weight %d out of range [%d, %d]", weight, subsystems.MinBlkioWeight, subsystems.MaxBlkioWeight)
+		}
+		resConf.BlkioWeight = uint16(weight)
+	}
+
+	throttles := []struct {
+		flag    string
+		isBps   bool
+		devices *[]*subsystems.ThrottleDevice
+	}{
+		{"device-read-bps", true, &resConf.BlkioDeviceReadBps},
+		{"device-write-bps", true, &resConf.BlkioDeviceWriteBps},
+		{"device-read-iops", false, &resConf.BlkioDeviceReadIOps},
+		{"device-write-iops", false, &resConf.BlkioDeviceWriteIOps},
+	}
+	for _, throttle := range throttles {
+		for _, value := range context.StringSlice(throttle.flag) {
+			device, err := subsystems.ParseThrottleDevice(value, throttle.isBps)
+			if err != nil {
+				return fmt.Errorf("cannot parse --%s: %v", throttle.flag, err)
+			}
+			*throttle.devices = append(*throttle.devices, device)
+		}
+	}
+	return nil
+}
+
+// parseDevices parses the extra devices of a box, and appends the extra rules to `resConf.Devices`
+func parseDevices(deviceFlags, ruleFlags []string, resConf *subsystems.ResourceConfig) ([]*subsystems.Device, error) {
+	var devices []*subsystems.Device
+	for _, deviceFlag := range deviceFlags {
+		device, err := subsystems.ParseDevice(deviceFlag)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse device: %v", err)
+		}
+		devices = append(devices, device)
+	}
+
+	for _, ruleFlag := range ruleFlags {
+		rule, err := subsystems.ParseDeviceRule(ruleFlag)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse device cgroup rule: %v", err)
+		}
+		resConf.Devices = append(resConf.Devices, rule)
+	}
+
+	return devices, nil
+}
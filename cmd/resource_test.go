@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"flag"
+	"github.com/urfave/cli"
+	"github.com/yqszxx/oreo-box/internal/cgroup/subsystems"
+	"io/ioutil"
+	"path"
+	"syscall"
+	"testing"
+)
+
+func resourceContext(t *testing.T, args ...string) *cli.Context {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, resourceFlag := range resourceFlags {
+		resourceFlag.Apply(set)
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(nil, set, nil)
+}
+
+// wholeDisk returns a disk of the host which can be throttled
+func wholeDisk(t *testing.T) string {
+	disks, err := ioutil.ReadDir("/sys/block")
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, disk := range disks {
+		var stat syscall.Stat_t
+		devicePath := path.Join("/dev", disk.Name())
+		if syscall.Stat(devicePath, &stat) == nil && stat.Mode&syscall.S_IFMT == syscall.S_IFBLK {
+			return devicePath
+		}
+	}
+	t.Skip("no block device")
+	return ""
+}
+
+func TestParseBlkio(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		args []string
+		want uint16
+	}{
+		{"unset", nil, 0},
+		{"lowest", []string{"--blkio-weight", "10"}, 10},
+		{"highest", []string{"--blkio-weight", "1000"}, 1000},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resConf := &subsystems.ResourceConfig{}
+			if err := parseBlkio(resourceContext(t, test.args...), resConf); err != nil {
+				t.Fatal(err)
+			}
+			if resConf.BlkioWeight != test.want {
+				t.Errorf("weight %d, want %d", resConf.BlkioWeight, test.want)
+			}
+		})
+	}
+}
+
+func TestParseBlkioThrottles(t *testing.T) {
+	disk := wholeDisk(t)
+	resConf := &subsystems.ResourceConfig{}
+	err := parseBlkio(resourceContext(t,
+		"--device-read-bps", disk+":1.5m",
+		"--device-write-bps", disk+":1024",
+		"--device-read-iops", disk+":1000",
+		"--device-write-iops", disk+":20",
+	), resConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name    string
+		devices []*subsystems.ThrottleDevice
+		rate    uint64
+	}{
+		{"read bps", resConf.BlkioDeviceReadBps, 3 << 19},
+		{"write bps", resConf.BlkioDeviceWriteBps, 1024},
+		{"read iops", resConf.BlkioDeviceReadIOps, 1000},
+		{"write iops", resConf.BlkioDeviceWriteIOps, 20},
+	} {
+		if len(test.devices) != 1 || test.devices[0].Rate != test.rate {
+			t.Errorf("%s throttles are %v, want one at %d", test.name, test.devices, test.rate)
+		}
+	}
+}
+
+func TestParseBlkioRejectsInvalid(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		args []string
+	}{
+		{"weight too high", []string{"--blkio-weight", "1001"}},
+		{"no rate", []string{"--device-read-bps", "/dev/sda"}},
+		{"empty rate", []string{"--device-read-bps", "/dev/sda:"}},
+		{"no device", []string{"--device-read-bps", ":1mb"}},
+		{"bad size", []string{"--device-write-bps", "/dev/sda:1x"}},
+		{"size as iops", []string{"--device-read-iops", "/dev/sda:1k"}},
+		{"zero rate", []string{"--device-write-iops", "/dev/sda:0"}},
+		{"not a block device", []string{"--device-read-bps", "/dev/null:1mb"}},
+		{"missing device", []string{"--device-read-bps", "/dev/oreo-box-missing:1mb"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := parseBlkio(resourceContext(t, test.args...), &subsystems.ResourceConfig{}); err == nil {
+				t.Error("parsing succeeded")
+			}
+		})
+	}
+}
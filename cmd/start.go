@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"github.com/yqszxx/oreo-box/pkg/oreobox"
+)
+
+var startCommand = cli.Command{
+	Name:   "start",
+	Usage:  "Start a stopped or exited box again",
+	Action: startHandler,
+}
+
+func startHandler(context *cli.Context) error {
+	if len(context.Args()) < 1 {
+		return fmt.Errorf("no box name provided")
+	}
+	boxName := context.Args().Get(0)
+
+	_, err := oreobox.New().Start(boxName)
+	return err
+}
@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli"
+	"github.com/yqszxx/oreo-box/internal"
+	"github.com/yqszxx/oreo-box/internal/cgroup"
+	"github.com/yqszxx/oreo-box/internal/cgroup/subsystems"
+	"github.com/yqszxx/oreo-box/internal/network"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var statsCommand = cli.Command{
+	Name:  "stats",
+	Usage: "Display live resource usage of boxes",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-stream",
+			Usage: "print a single result instead of refreshing",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "output format, table or json",
+			Value: "table",
+		},
+		cli.DurationFlag{
+			Name:  "interval",
+			Usage: "interval over which CPU usage is computed",
+			Value: time.Second,
+		},
+	},
+	Action: statsHandler,
+}
+
+type boxStats struct {
+	Id            string                `json:"id"`
+	Name          string                `json:"name"`
+	CpuPercent    float64               `json:"cpuPercent"`
+	MemoryUsage   uint64                `json:"memoryUsage"`
+	MemoryLimit   uint64                `json:"memoryLimit"`
+	MemoryPercent float64               `json:"memoryPercent"`
+	Pids          *subsystems.PidsStats `json:"pids"`
+	Network       *network.NetworkStats `json:"network,omitempty"`
+	BlkioRead     uint64                `json:"blkioRead"`
+	BlkioWrite    uint64                `json:"blkioWrite"`
+}
+
+type statsSample struct {
+	stats *subsystems.Stats
+	time  time.Time
+}
+
+func statsHandler(context *cli.Context) error {
+	format := context.String("format")
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unknown format `%s`", format)
+	}
+	interval := context.Duration("interval")
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+
+	boxes, err := runningBoxes(context.Args())
+	if err != nil {
+		return err
+	}
+	if len(boxes) == 0 {
+		return fmt.Errorf("no running box")
+	}
+
+	memTotal, err := hostMemoryTotal()
+	if err != nil {
+		return err
+	}
+
+	previous := sampleBoxes(boxes)
+	for {
+		time.Sleep(interval)
+		current := sampleBoxes(boxes)
+
+		var results []*boxStats
+		for _, box := range boxes {
+			before, after := previous[box.Id], current[box.Id]
+			if before == nil || after == nil {
+				continue
+			}
+			results = append(results, computeBoxStats(box, before, after, memTotal))
+		}
+		previous = current
+
+		if !context.Bool("no-stream") && format == "table" {
+			// clear the screen before redrawing
+			fmt.Print("\033[2J\033[H")
+		}
+		if err := printBoxStats(results, format); err != nil {
+			return err
+		}
+		if context.Bool("no-stream") {
+			return nil
+		}
+	}
+}
+
+// runningBoxes returns the boxes named in `names`, or every running box when no name is given
+func runningBoxes(names []string) ([]*internal.BoxInfo, error) {
+	var boxes []*internal.BoxInfo
+	if len(names) > 0 {
+		for _, name := range names {
+			boxInfo, err := internal.GetBoxInfoByName(name)
+			if err != nil {
+				return nil, fmt.Errorf("fail to get box %s info : %v", name, err)
+			}
+			if boxInfo.Status != internal.Running {
+				return nil, fmt.Errorf("box `%s` is not running", name)
+			}
+			boxes = append(boxes, boxInfo)
+		}
+		return boxes, nil
+	}
+
+	allBoxes, err := internal.GetAllBoxInfos()
+	if err != nil {
+		return nil, err
+	}
+	for _, boxInfo := range allBoxes {
+		if boxInfo.Status == internal.Running {
+			boxes = append(boxes, boxInfo)
+		}
+	}
+	return boxes, nil
+}
+
+func sampleBoxes(boxes []*internal.BoxInfo) map[string]*statsSample {
+	samples := map[string]*statsSample{}
+	for _, box := range boxes {
+		stats, err := cgroup.NewCgroupManager(box.Id).GetStats()
+		if err != nil {
+			log.Printf("cannot get stats of box `%s`: %v", box.Name, err)
+			continue
+		}
+		samples[box.Id] = &statsSample{stats: stats, time: time.Now()}
+	}
+	return samples
+}
+
+func computeBoxStats(box *internal.BoxInfo, before, after *statsSample, memTotal uint64) *boxStats {
+	result := &boxStats{
+		Id:          box.Id,
+		Name:        box.Name,
+		MemoryUsage: after.stats.MemoryUsage,
+		MemoryLimit: after.stats.MemoryLimit,
+		Pids:        after.stats.Pids,
+		BlkioRead:   after.stats.BlkioRead,
+		BlkioWrite:  after.stats.BlkioWrite,
+	}
+
+	// percentage of one CPU, a box using two CPUs fully shows 200%
+	wall := after.time.Sub(before.time).Nanoseconds()
+	if wall > 0 && after.stats.CpuUsageNs >= before.stats.CpuUsageNs {
+		result.CpuPercent = float64(after.stats.CpuUsageNs-before.stats.CpuUsageNs) / float64(wall) * 100
+	}
+
+	if result.MemoryLimit == 0 || result.MemoryLimit > memTotal {
+		result.MemoryLimit = memTotal
+	}
+	if result.MemoryLimit > 0 {
+		result.MemoryPercent = float64(result.MemoryUsage) / float64(result.MemoryLimit) * 100
+	}
+
+	if box.Network != "" {
+		networkStats, err := network.EndpointStats(box.Id, box.Network)
+		if err != nil {
+			log.Printf("cannot get network stats of box `%s`: %v", box.Name, err)
+		} else {
+			result.Network = networkStats
+		}
+	}
+	return result
+}
+
+func printBoxStats(results []*boxStats, format string) error {
+	if format == "json" {
+		resultBytes, err := json.Marshal(results)
+		if err != nil {
+			return fmt.Errorf("cannot marshal stats: %v", err)
+		}
+		fmt.Println(string(resultBytes))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	if _, err := fmt.Fprint(w, "ID\tNAME\tCPU %\tMEM USAGE / LIMIT\tMEM %\tNET I/O\tBLOCK I/O\tPIDS\n"); err != nil {
+		return fmt.Errorf("fail to exec fmt.Fprint : %v", err)
+	}
+	for _, item := range results {
+		netIO := "--"
+		if item.Network != nil {
+			netIO = subsystems.HumanSize(item.Network.RxBytes) + " / " + subsystems.HumanSize(item.Network.TxBytes)
+		}
+		pids := "--"
+		if item.Pids != nil {
+			pids = strconv.FormatUint(item.Pids.Current, 10)
+		}
+		_, err := fmt.Fprintf(w, "%s\t%s\t%.2f%%\t%s / %s\t%.2f%%\t%s\t%s / %s\t%s\n",
+			item.Id,
+			item.Name,
+			item.CpuPercent,
+			subsystems.HumanSize(item.MemoryUsage),
+			subsystems.HumanSize(item.MemoryLimit),
+			item.MemoryPercent,
+			netIO,
+			subsystems.HumanSize(item.BlkioRead),
+			subsystems.HumanSize(item.BlkioWrite),
+			pids)
+		if err != nil {
+			return fmt.Errorf("fail to exec fmt.Fprintf %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("cannot flush : %v", err)
+	}
+	return nil
+}
+
+// hostMemoryTotal reads `MemTotal` from /proc/meminfo in bytes
+func hostMemoryTotal() (uint64, error) {
+	content, err := ioutil.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, fmt.Errorf("cannot read /proc/meminfo: %v", err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("cannot parse MemTotal `%s`: %v", fields[1], err)
+			}
+			return kb * 1024, nil
+		}
+	}
+	return 0, fmt.Errorf("no MemTotal in /proc/meminfo")
+}
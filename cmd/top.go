@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"github.com/yqszxx/oreo-box/internal"
+	"github.com/yqszxx/oreo-box/internal/cgroup"
+	"github.com/yqszxx/oreo-box/internal/cgroup/subsystems"
+	"log"
+	"os"
+	"text/tabwriter"
+)
+
+var topCommand = cli.Command{
+	Name:   "top",
+	Usage:  "List processes running inside a box",
+	Action: topHandler,
+}
+
+func topHandler(context *cli.Context) error {
+	if len(context.Args()) < 1 {
+		return fmt.Errorf("no box name provided")
+	}
+	boxName := context.Args().Get(0)
+
+	boxInfo, err := internal.GetBoxInfoByName(boxName)
+	if err != nil {
+		return fmt.Errorf("fail to get box %s info : %v", boxName, err)
+	}
+	if boxInfo.Status != internal.Running {
+		return fmt.Errorf("box `%s` is not running", boxName)
+	}
+
+	pids, err := cgroup.NewCgroupManager(boxInfo.Id).GetPids()
+	if err != nil {
+		return fmt.Errorf("cannot list processes of box `%s`: %v", boxName, err)
+	}
+
+	memTotal, err := hostMemoryTotal()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	if _, err := fmt.Fprint(w, "PID\tBOX PID\tUSER\t%CPU\t%MEM\tRSS\tCOMMAND\n"); err != nil {
+		return fmt.Errorf("fail to exec fmt.Fprint : %v", err)
+	}
+	for _, pid := range pids {
+		info, err := internal.GetProcessInfo(pid)
+		if err != nil {
+			// the process may have exited after the cgroup was read
+			log.Printf("cannot get info of process %d: %v", pid, err)
+			continue
+		}
+		_, err = fmt.Fprintf(w, "%d\t%d\t%s\t%.1f\t%.1f\t%s\t%s\n",
+			info.Pid,
+			info.NsPid,
+			info.User,
+			info.CpuPercent,
+			float64(info.RssBytes)/float64(memTotal)*100,
+			subsystems.HumanSize(info.RssBytes),
+			info.Command)
+		if err != nil {
+			return fmt.Errorf("fail to exec fmt.Fprintf %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("cannot flush : %v", err)
+	}
+	return nil
+}
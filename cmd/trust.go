@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"github.com/yqszxx/oreo-box/pkg/oreobox"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var trustCommand = cli.Command{
+	Name:  "trust",
+	Usage: "Manage the keys trusted to sign images",
+	Subcommands: []cli.Command{
+		{
+			Name:      "add",
+			Usage:     "Trust the armored public keys in files",
+			ArgsUsage: "FILE [FILE...]",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("no key file provided")
+				}
+				for _, keyPath := range context.Args() {
+					keyFile, err := os.Open(keyPath)
+					if err != nil {
+						return fmt.Errorf("cannot open key file `%s`: %v", keyPath, err)
+					}
+					keys, err := oreobox.TrustKeys(keyFile)
+					_ = keyFile.Close()
+					for _, key := range keys {
+						fmt.Println(key.Fingerprint)
+					}
+					if err != nil {
+						return fmt.Errorf("cannot trust keys in `%s`: %v", keyPath, err)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "List trusted keys",
+			Action: func(context *cli.Context) error {
+				keys, err := oreobox.TrustedKeys()
+				if err != nil {
+					return err
+				}
+				return printKeys(keys)
+			},
+		},
+		{
+			Name:      "remove",
+			Usage:     "Stop trusting a key",
+			ArgsUsage: "FINGERPRINT|KEYID",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("no key provided")
+				}
+				key, err := oreobox.DistrustKey(context.Args().Get(0))
+				if err != nil {
+					return err
+				}
+				fmt.Println(key.Fingerprint)
+				return nil
+			},
+		},
+	},
+}
+
+func printKeys(keys []*oreobox.TrustedKey) error {
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	if _, err := fmt.Fprint(w, "FINGERPRINT\tIDENTITIES\tCREATED\tEXPIRES\tSTATUS\n"); err != nil {
+		return fmt.Errorf("fail to exec fmt.Fprint : %v", err)
+	}
+	for _, key := range keys {
+		expires := "never"
+		if !key.Expires.IsZero() {
+			expires = key.Expires.Format("2006-01-02")
+		}
+		status := "valid"
+		switch {
+		case key.Revoked:
+			status = "revoked"
+		case !key.Expires.IsZero() && key.Expires.Before(time.Now()):
+			status = "expired"
+		}
+		if key.Builtin {
+			status += " (built-in)"
+		}
+		_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			key.Fingerprint,
+			strings.Join(key.Identities, ", "),
+			key.Created.Format("2006-01-02"),
+			expires,
+			status)
+		if err != nil {
+			return fmt.Errorf("fail to exec fmt.Fprintf %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("cannot flush : %v", err)
+	}
+	return nil
+}
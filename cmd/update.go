@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"github.com/yqszxx/oreo-box/pkg/oreobox"
+)
+
+var updateCommand = cli.Command{
+	Name:   "update",
+	Usage:  "Update resource limits of running boxes",
+	Flags:  resourceFlags,
+	Action: updateHandler,
+}
+
+func updateHandler(context *cli.Context) error {
+	if len(context.Args()) < 1 {
+		return fmt.Errorf("no box name provided")
+	}
+
+	update, err := parseResourceConfig(context)
+	if err != nil {
+		return err
+	}
+
+	client := oreobox.New()
+	for _, boxName := range context.Args() {
+		if err := client.Update(boxName, update); err != nil {
+			return err
+		}
+		fmt.Println(boxName)
+	}
+	return nil
+}
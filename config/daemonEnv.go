@@ -0,0 +1,4 @@
+package config
+
+// EnvDaemonSocket overrides `DaemonSocketPath` for the CLI
+const EnvDaemonSocket = "OB_SOCKET"
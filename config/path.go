@@ -4,12 +4,21 @@ const (
 	Root              = "/var/lib/oreo-box/"
 	ImagePath         = Root + "image/"
 	ImageTempPath     = "/tmp/oreo-box/image/"
+	TagIndexFileName  = "tags.json"
+	ImageMetadataPath = Root + "imagedb/"
+	LayerPath         = Root + "layers/"
+	LayerLockFileName = ".lock"
 	BoxDataPath       = Root + "box/"
 	InfoFileName      = "config.json"
+	StartFileName     = "start.json"
 	LogFileName       = "output.log"
 	SignatureFileName = "signature.asc"
 	ImageDataFileName = "image.tar"
 	MountPath         = "rootfs/"
 	WritableLayerPath = "writableLayer/"
 	NetworkPath       = Root + "network/"
+	EventsFilePath    = Root + "events.log"
+	DaemonSocketPath  = "/run/oreo-box.sock"
+	OciStatePath      = "/run/oreo-box/oci/"
+	TrustPath         = "/etc/oreo-box/trusted.d/"
 )
@@ -1,8 +1,9 @@
 module github.com/yqszxx/oreo-box
 
-go 1.14
+go 1.22
 
 require (
+	github.com/klauspost/compress v1.18.0
 	github.com/urfave/cli v1.19.1
 	github.com/vishvananda/netlink v0.0.0-20170105235913-1890b34fa39d
 	github.com/vishvananda/netns v0.0.0-20161219181606-2c9454e4fc6e
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"fmt"
+	"github.com/yqszxx/oreo-box/internal/image"
+)
+
+// Version is the prefix of every API path, bump it on incompatible changes
+const Version = "v1"
+
+// streamProtocol is the `Upgrade` protocol of requests which attach to the standard streams of a process,
+// after the `101 Switching Protocols` response the connection carries stdin one way and stdout and stderr the other
+const streamProtocol = "oreo-box-stream"
+
+// Error is the body of every non-2xx response
+type Error struct {
+	StatusCode int    `json:"-"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+type VersionResponse struct {
+	ApiVersion string `json:"apiVersion"`
+}
+
+type ImportImageRequest struct {
+	Name string `json:"name"`
+	// Path is the location of the image file on the daemon host
+	Path string `json:"path"`
+	image.ImportOptions
+}
+
+type CreateNetworkRequest struct {
+	Name   string `json:"name"`
+	Driver string `json:"driver"`
+	Subnet string `json:"subnet"`
+}
+
+type ExecRequest struct {
+	Args []string `json:"args"`
+}
+
+type SaveImageRequest struct {
+	SignKeyPath string `json:"signKeyPath"`
+}
+
+type TagImageRequest struct {
+	Tag   string `json:"tag"`
+	Force bool   `json:"force"`
+}
+
+type CommitRequest struct {
+	Image string `json:"image"`
+	image.CommitOptions
+}
+
+func versioned(format string, a ...interface{}) string {
+	return "/" + Version + fmt.Sprintf(format, a...)
+}
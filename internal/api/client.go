@@ -0,0 +1,317 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/yqszxx/oreo-box/internal"
+	"github.com/yqszxx/oreo-box/internal/box"
+	"github.com/yqszxx/oreo-box/internal/cgroup/subsystems"
+	"github.com/yqszxx/oreo-box/internal/image"
+	"github.com/yqszxx/oreo-box/internal/network"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Client talks to the daemon listening on a unix socket
+type Client struct {
+	socketPath string
+	http       *http.Client
+}
+
+func NewClient(socketPath string) *Client {
+	return &Client{
+		socketPath: socketPath,
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+func (c *Client) newRequest(method, apiPath string, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("cannot encode request body: %v", err)
+		}
+		reader = bytes.NewReader(bodyBytes)
+	}
+	// the host is ignored as every connection goes to the socket
+	req, err := http.NewRequest(method, "http://oreo-box"+apiPath, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+func responseError(resp *http.Response) error {
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	bodyBytes, _ := ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(bodyBytes, apiErr); err != nil || apiErr.Message == "" {
+		apiErr.Message = fmt.Sprintf("daemon responded %s", resp.Status)
+	}
+	return apiErr
+}
+
+// do sends a request and decodes the JSON response into `out` unless it is nil
+func (c *Client) do(method, apiPath string, body, out interface{}) error {
+	req, err := c.newRequest(method, apiPath, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot reach daemon at `%s`: %v", c.socketPath, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode/100 != 2 {
+		return responseError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("cannot decode response: %v", err)
+	}
+	return nil
+}
+
+// stream sends an upgrade request, then copies `stdin` to the daemon and its output to `stdout` until the process exits
+func (c *Client) stream(apiPath string, body interface{}, stdin io.Reader, stdout io.Writer) error {
+	req, err := c.newRequest(http.MethodPost, apiPath, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", streamProtocol)
+
+	conn, err := net.Dial("unix", c.socketPath)
+	if err != nil {
+		return fmt.Errorf("cannot reach daemon at `%s`: %v", c.socketPath, err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("cannot send request: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return fmt.Errorf("cannot read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		return responseError(resp)
+	}
+
+	if stdin != nil {
+		go func() {
+			_, _ = io.Copy(conn, stdin)
+			// let the process see the end of its input
+			if unixConn, ok := conn.(*net.UnixConn); ok {
+				_ = unixConn.CloseWrite()
+			}
+		}()
+	}
+	if _, err := io.Copy(stdout, reader); err != nil {
+		return fmt.Errorf("stream interrupted: %v", err)
+	}
+	return nil
+}
+
+// Ping checks whether the daemon is up and speaks this API version
+func (c *Client) Ping() error {
+	versionResponse := &VersionResponse{}
+	if err := c.do(http.MethodGet, versioned("/version"), nil, versionResponse); err != nil {
+		return err
+	}
+	if versionResponse.ApiVersion != Version {
+		return fmt.Errorf("daemon speaks API %s, expected %s", versionResponse.ApiVersion, Version)
+	}
+	return nil
+}
+
+func (c *Client) ListBoxes() ([]*internal.BoxInfo, error) {
+	var boxes []*internal.BoxInfo
+	if err := c.do(http.MethodGet, versioned("/boxes"), nil, &boxes); err != nil {
+		return nil, err
+	}
+	return boxes, nil
+}
+
+// RunBox creates and starts a box, an interactive box is attached to the streams of `spec` and nil is returned once it exits
+func (c *Client) RunBox(spec *box.Spec) (*internal.BoxInfo, error) {
+	if spec.Interactive {
+		return nil, c.stream(versioned("/boxes"), spec, spec.Stdin, spec.Stdout)
+	}
+	boxInfo := &internal.BoxInfo{}
+	if err := c.do(http.MethodPost, versioned("/boxes"), spec, boxInfo); err != nil {
+		return nil, err
+	}
+	return boxInfo, nil
+}
+
+func (c *Client) InspectBox(name string) (*box.Detail, error) {
+	detail := &box.Detail{}
+	if err := c.do(http.MethodGet, versioned("/boxes/%s", url.PathEscape(name)), nil, detail); err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+func (c *Client) StartBox(name string) (*internal.BoxInfo, error) {
+	boxInfo := &internal.BoxInfo{}
+	if err := c.do(http.MethodPost, versioned("/boxes/%s/start", url.PathEscape(name)), nil, boxInfo); err != nil {
+		return nil, err
+	}
+	return boxInfo, nil
+}
+
+func (c *Client) StopBox(name string) error {
+	return c.do(http.MethodPost, versioned("/boxes/%s/stop", url.PathEscape(name)), nil, nil)
+}
+
+func (c *Client) RemoveBox(name string) error {
+	return c.do(http.MethodDelete, versioned("/boxes/%s", url.PathEscape(name)), nil, nil)
+}
+
+func (c *Client) UpdateBox(name string, update *subsystems.ResourceConfig) error {
+	return c.do(http.MethodPost, versioned("/boxes/%s/update", url.PathEscape(name)), update, nil)
+}
+
+// BoxLogs copies the output of a detached box to `w`
+func (c *Client) BoxLogs(name string, w io.Writer) error {
+	return c.download(http.MethodGet, versioned("/boxes/%s/logs", url.PathEscape(name)), nil, w)
+}
+
+func (c *Client) ExportBox(name string, w io.Writer) error {
+	return c.download(http.MethodGet, versioned("/boxes/%s/export", url.PathEscape(name)), nil, w)
+}
+
+func (c *Client) SaveImage(name, signKeyPath string, w io.Writer) error {
+	return c.download(http.MethodPost, versioned("/images/%s/save", url.PathEscape(name)), &SaveImageRequest{SignKeyPath: signKeyPath}, w)
+}
+
+// download copies the body of a successful response to `w`
+func (c *Client) download(method, apiPath string, body interface{}, w io.Writer) error {
+	req, err := c.newRequest(method, apiPath, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot reach daemon at `%s`: %v", c.socketPath, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (c *Client) CommitBox(name, imageName string, options *image.CommitOptions) (*image.Metadata, error) {
+	metadata := &image.Metadata{}
+	request := &CommitRequest{Image: imageName, CommitOptions: *options}
+	if err := c.do(http.MethodPost, versioned("/boxes/%s/commit", url.PathEscape(name)), request, metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// ExecBox runs a command inside a box attached to `stdin` and `stdout`
+func (c *Client) ExecBox(name string, args []string, stdin io.Reader, stdout io.Writer) error {
+	return c.stream(versioned("/boxes/%s/exec", url.PathEscape(name)), &ExecRequest{Args: args}, stdin, stdout)
+}
+
+// ListImages lists all images, or those `references` refer to
+func (c *Client) ListImages(references ...string) ([]string, error) {
+	endpoint := versioned("/images")
+	if len(references) > 0 {
+		endpoint += "?" + url.Values{"reference": references}.Encode()
+	}
+	var images []string
+	if err := c.do(http.MethodGet, endpoint, nil, &images); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (c *Client) InspectImage(reference string) (*image.Detail, error) {
+	detail := &image.Detail{}
+	if err := c.do(http.MethodGet, versioned("/images/%s", url.PathEscape(reference)), nil, detail); err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+func (c *Client) ImageHistory(reference string) ([]*image.HistoryEntry, error) {
+	var entries []*image.HistoryEntry
+	if err := c.do(http.MethodGet, versioned("/images/%s/history", url.PathEscape(reference)), nil, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (c *Client) TagImage(reference, tag string, force bool) error {
+	return c.do(http.MethodPost, versioned("/images/%s/tag", url.PathEscape(reference)), &TagImageRequest{Tag: tag, Force: force}, nil)
+}
+
+// ImportImage imports the image file at `path` on the daemon host
+func (c *Client) ImportImage(name, path string, options *image.ImportOptions) error {
+	return c.do(http.MethodPost, versioned("/images"), &ImportImageRequest{Name: name, Path: path, ImportOptions: *options}, nil)
+}
+
+func (c *Client) PruneImages() (*image.PruneReport, error) {
+	report := &image.PruneReport{}
+	if err := c.do(http.MethodPost, versioned("/images/prune"), nil, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (c *Client) RemoveImage(name string, force bool) error {
+	endpoint := versioned("/images/%s", url.PathEscape(name))
+	if force {
+		endpoint += "?force=1"
+	}
+	return c.do(http.MethodDelete, endpoint, nil, nil)
+}
+
+func (c *Client) ListNetworks() ([]*network.Network, error) {
+	var nws []*network.Network
+	if err := c.do(http.MethodGet, versioned("/networks"), nil, &nws); err != nil {
+		return nil, err
+	}
+	return nws, nil
+}
+
+func (c *Client) CreateNetwork(name, driver, subnet string) error {
+	return c.do(http.MethodPost, versioned("/networks"), &CreateNetworkRequest{Name: name, Driver: driver, Subnet: subnet}, nil)
+}
+
+func (c *Client) RemoveNetwork(name string) error {
+	return c.do(http.MethodDelete, versioned("/networks/%s", url.PathEscape(name)), nil, nil)
+}
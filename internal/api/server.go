@@ -0,0 +1,481 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/yqszxx/oreo-box/internal/box"
+	"github.com/yqszxx/oreo-box/internal/cgroup/subsystems"
+	"github.com/yqszxx/oreo-box/internal/image"
+	"github.com/yqszxx/oreo-box/internal/network"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Server serves the API, routes are
+//
+//	GET    /v1/version
+//	GET    /v1/boxes                    list boxes
+//	POST   /v1/boxes                    create and start a box from a `box.Spec`, streams if interactive
+//	GET    /v1/boxes/{name}             inspect a box
+//	DELETE /v1/boxes/{name}             remove a box
+//	POST   /v1/boxes/{name}/start       start a stopped or exited box again
+//	POST   /v1/boxes/{name}/stop        stop a box
+//	POST   /v1/boxes/{name}/update      update resource limits of a box
+//	GET    /v1/boxes/{name}/logs        output of a detached box
+//	POST   /v1/boxes/{name}/exec        run a command inside a box, always streams
+//	POST   /v1/boxes/{name}/commit      create an image from a box
+//	GET    /v1/boxes/{name}/export      tarball of the filesystem of a box
+//	GET    /v1/images                   list images, those given by `?reference=` if any
+//	POST   /v1/images                   import an image
+//	POST   /v1/images/prune             remove layers no image references
+//	GET    /v1/images/{name}            inspect an image, by name, tag or digest as are the routes below
+//	POST   /v1/images/{name}/save       image in the signed format
+//	POST   /v1/images/{name}/tag        tag an image
+//	GET    /v1/images/{name}/history    how a layered image was built
+//	DELETE /v1/images/{name}            remove an image or a tag, `?force=1` even if boxes use the image
+//	GET    /v1/networks                 list networks
+//	POST   /v1/networks                 create a network
+//	DELETE /v1/networks/{name}          remove a network
+type Server struct {
+	mux *http.ServeMux
+}
+
+func NewServer() *Server {
+	s := &Server{mux: http.NewServeMux()}
+	s.mux.HandleFunc(versioned("/version"), s.version)
+	s.mux.HandleFunc(versioned("/boxes"), s.boxes)
+	s.mux.HandleFunc(versioned("/boxes/"), s.box)
+	s.mux.HandleFunc(versioned("/images"), s.images)
+	s.mux.HandleFunc(versioned("/images/"), s.image)
+	s.mux.HandleFunc(versioned("/networks"), s.networks)
+	s.mux.HandleFunc(versioned("/networks/"), s.network)
+	return s
+}
+
+// Serve accepts API connections on `listener` until it is closed
+func (s *Server) Serve(listener net.Listener) error {
+	return http.Serve(listener, s)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("%s %s", r.Method, r.URL.Path)
+	s.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if v == nil {
+		return
+	}
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("cannot write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	statusCode := http.StatusInternalServerError
+	switch e := err.(type) {
+	case *Error:
+		statusCode = e.StatusCode
+	case *box.NotFoundError, *image.NotFoundError:
+		statusCode = http.StatusNotFound
+	case *image.InUseError:
+		statusCode = http.StatusConflict
+	}
+	writeJSON(w, statusCode, &Error{Message: err.Error()})
+}
+
+// download sends what `write` writes, an error is sent as such until anything has been written
+func download(w http.ResponseWriter, contentType string, write func(io.Writer) error) {
+	out := &lazyWriter{ResponseWriter: w, contentType: contentType}
+	if err := write(out); err != nil {
+		if !out.started {
+			writeError(w, err)
+			return
+		}
+		log.Printf("cannot finish download: %v", err)
+	}
+}
+
+// lazyWriter sends the response header on the first write
+type lazyWriter struct {
+	http.ResponseWriter
+	contentType string
+	started     bool
+}
+
+func (l *lazyWriter) Write(p []byte) (int, error) {
+	if !l.started {
+		l.started = true
+		l.Header().Set("Content-Type", l.contentType)
+		l.WriteHeader(http.StatusOK)
+	}
+	return l.ResponseWriter.Write(p)
+}
+
+func badRequest(format string, a ...interface{}) error {
+	return &Error{StatusCode: http.StatusBadRequest, Message: fmt.Sprintf(format, a...)}
+}
+
+func methodNotAllowed(r *http.Request) error {
+	return &Error{StatusCode: http.StatusMethodNotAllowed, Message: fmt.Sprintf("method %s not allowed on %s", r.Method, r.URL.Path)}
+}
+
+func notFound(r *http.Request) error {
+	return &Error{StatusCode: http.StatusNotFound, Message: fmt.Sprintf("no such endpoint %s", r.URL.Path)}
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return badRequest("cannot decode request body: %v", err)
+	}
+	return nil
+}
+
+// pathParts splits the path after `prefix` into its segments
+func pathParts(r *http.Request, prefix string) []string {
+	return strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, versioned(prefix)), "/"), "/")
+}
+
+// attach takes over the connection of an upgrade request, the returned reader and connection are
+// the standard streams of the process to be run, the connection is to be closed once it exits
+func attach(w http.ResponseWriter, r *http.Request) (io.Reader, net.Conn, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), streamProtocol) {
+		return nil, nil, badRequest("request must upgrade to `%s`", streamProtocol)
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("connection cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot hijack connection: %v", err)
+	}
+	if _, err := fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", streamProtocol); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	// the reader may already hold the beginning of stdin
+	return rw.Reader, conn, nil
+}
+
+// detach reports the result of an attached process and closes its connection
+func detach(conn net.Conn, err error) {
+	if err != nil {
+		_, _ = fmt.Fprintf(conn, "Error: %v\n", err)
+	}
+	if err := conn.Close(); err != nil {
+		log.Printf("cannot close stream: %v", err)
+	}
+}
+
+func (s *Server) version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, &VersionResponse{ApiVersion: Version})
+}
+
+func (s *Server) boxes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		boxes, err := box.List()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, boxes)
+	case http.MethodPost:
+		spec := &box.Spec{}
+		if err := readJSON(r, spec); err != nil {
+			writeError(w, err)
+			return
+		}
+		if spec.Image == "" {
+			writeError(w, badRequest("no image provided"))
+			return
+		}
+		if !spec.Interactive {
+			boxInfo, err := box.Run(spec)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusCreated, boxInfo)
+			return
+		}
+		stdin, conn, err := attach(w, r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		spec.Stdin, spec.Stdout, spec.Stderr = stdin, conn, conn
+		_, err = box.Run(spec)
+		detach(conn, err)
+	default:
+		writeError(w, methodNotAllowed(r))
+	}
+}
+
+func (s *Server) box(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r, "/boxes")
+	name := parts[0]
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	} else if len(parts) > 2 {
+		writeError(w, notFound(r))
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		detail, err := box.Inspect(name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, detail)
+	case action == "" && r.Method == http.MethodDelete:
+		if err := box.Remove(name); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusNoContent, nil)
+	case action == "start" && r.Method == http.MethodPost:
+		boxInfo, err := box.Start(name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, boxInfo)
+	case action == "stop" && r.Method == http.MethodPost:
+		if err := box.Stop(name); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusNoContent, nil)
+	case action == "update" && r.Method == http.MethodPost:
+		update := &subsystems.ResourceConfig{}
+		if err := readJSON(r, update); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := box.Update(name, update); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusNoContent, nil)
+	case action == "logs" && r.Method == http.MethodGet:
+		logs, err := box.Logs(name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer func() {
+			if err := logs.Close(); err != nil {
+				log.Printf("cannot close logs of box `%s`: %v", name, err)
+			}
+		}()
+		w.Header().Set("Content-Type", "text/plain")
+		if _, err := io.Copy(w, logs); err != nil {
+			log.Printf("cannot send logs of box `%s`: %v", name, err)
+		}
+	case action == "commit" && r.Method == http.MethodPost:
+		commitRequest := &CommitRequest{}
+		if err := readJSON(r, commitRequest); err != nil {
+			writeError(w, err)
+			return
+		}
+		if commitRequest.Image == "" {
+			writeError(w, badRequest("image name is required"))
+			return
+		}
+		metadata, err := box.Commit(name, commitRequest.Image, &commitRequest.CommitOptions)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, metadata)
+	case action == "export" && r.Method == http.MethodGet:
+		download(w, "application/x-tar", func(out io.Writer) error {
+			return box.Export(name, out)
+		})
+	case action == "exec" && r.Method == http.MethodPost:
+		execRequest := &ExecRequest{}
+		if err := readJSON(r, execRequest); err != nil {
+			writeError(w, err)
+			return
+		}
+		if len(execRequest.Args) == 0 {
+			writeError(w, badRequest("no command provided"))
+			return
+		}
+		// report a missing box before taking over the connection
+		if _, err := box.Get(name); err != nil {
+			writeError(w, err)
+			return
+		}
+		stdin, conn, err := attach(w, r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		detach(conn, box.Exec(name, execRequest.Args, stdin, conn, conn))
+	case action == "" || action == "start" || action == "stop" || action == "update" || action == "logs" || action == "exec" ||
+		action == "commit" || action == "export":
+		writeError(w, methodNotAllowed(r))
+	default:
+		writeError(w, notFound(r))
+	}
+}
+
+func (s *Server) images(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		images, err := image.Names(r.URL.Query()["reference"]...)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if images == nil {
+			images = []string{}
+		}
+		writeJSON(w, http.StatusOK, images)
+	case http.MethodPost:
+		importRequest := &ImportImageRequest{}
+		if err := readJSON(r, importRequest); err != nil {
+			writeError(w, err)
+			return
+		}
+		if importRequest.Name == "" || importRequest.Path == "" {
+			writeError(w, badRequest("image name and path are required"))
+			return
+		}
+		if err := image.Import(importRequest.Name, importRequest.Path, &importRequest.ImportOptions); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusNoContent, nil)
+	default:
+		writeError(w, methodNotAllowed(r))
+	}
+}
+
+func (s *Server) image(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r, "/images")
+	if len(parts) == 2 && parts[1] == "save" && r.Method == http.MethodPost {
+		saveRequest := &SaveImageRequest{}
+		if err := readJSON(r, saveRequest); err != nil {
+			writeError(w, err)
+			return
+		}
+		download(w, "application/x-tar", func(out io.Writer) error {
+			return image.Save(parts[0], out, saveRequest.SignKeyPath)
+		})
+		return
+	}
+	if len(parts) == 2 && parts[1] == "history" && r.Method == http.MethodGet {
+		entries, err := image.LayerHistory(parts[0])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+		return
+	}
+	if len(parts) == 2 && parts[1] == "tag" && r.Method == http.MethodPost {
+		tagRequest := &TagImageRequest{}
+		if err := readJSON(r, tagRequest); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := image.Tag(parts[0], tagRequest.Tag, tagRequest.Force); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusNoContent, nil)
+		return
+	}
+	if len(parts) != 1 {
+		writeError(w, notFound(r))
+		return
+	}
+	if parts[0] == "prune" && r.Method == http.MethodPost {
+		report, err := image.Prune()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+	if r.Method == http.MethodGet {
+		detail, err := image.Inspect(parts[0])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, detail)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		writeError(w, methodNotAllowed(r))
+		return
+	}
+	if err := image.Remove(parts[0], r.URL.Query().Get("force") == "1"); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusNoContent, nil)
+}
+
+func (s *Server) networks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		nws := network.Networks()
+		if nws == nil {
+			nws = []*network.Network{}
+		}
+		writeJSON(w, http.StatusOK, nws)
+	case http.MethodPost:
+		createRequest := &CreateNetworkRequest{}
+		if err := readJSON(r, createRequest); err != nil {
+			writeError(w, err)
+			return
+		}
+		if createRequest.Name == "" {
+			writeError(w, badRequest("no network name provided"))
+			return
+		}
+		if err := network.CreateNetwork(createRequest.Driver, createRequest.Subnet, createRequest.Name); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusNoContent, nil)
+	default:
+		writeError(w, methodNotAllowed(r))
+	}
+}
+
+func (s *Server) network(w http.ResponseWriter, r *http.Request) {
+	parts := pathParts(r, "/networks")
+	if len(parts) != 1 {
+		writeError(w, notFound(r))
+		return
+	}
+	if r.Method != http.MethodDelete {
+		writeError(w, methodNotAllowed(r))
+		return
+	}
+	if err := network.DeleteNetwork(parts[0]); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusNoContent, nil)
+}
@@ -0,0 +1,264 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/yqszxx/oreo-box/internal/cgroup/subsystems"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const xattrPrefix = "SCHILY.xattr."
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Decompress wraps `r` in a decompressing reader if it is gzip or zstd compressed
+func Decompress(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(buffered)
+	case bytes.HasPrefix(magic, zstdMagic):
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return io.NopCloser(buffered), nil
+	}
+}
+
+// Extract extracts a tarball, which may be compressed, into `dest`. Entries may not leave `dest`
+// or be written through symlinks, ownership is kept when running as root
+func Extract(r io.Reader, dest string) error {
+	uncompressed, err := Decompress(r)
+	if err != nil {
+		return fmt.Errorf("cannot decompress archive: %v", err)
+	}
+	defer func() {
+		_ = uncompressed.Close()
+	}()
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("cannot create dir `%s`: %v", dest, err)
+	}
+	dest, err = filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+
+	oldMask := syscall.Umask(0)
+	defer syscall.Umask(oldMask)
+
+	// dirs get their mode and times once their contents are written
+	var dirs []*tar.Header
+	reader := tar.NewReader(uncompressed)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read archive: %v", err)
+		}
+		target, err := resolve(dest, header.Name)
+		if err != nil {
+			return err
+		}
+		if target == dest {
+			if header.Typeflag == tar.TypeDir {
+				dirs = append(dirs, header)
+			}
+			continue
+		}
+		if err := extractEntry(dest, target, header, reader); err != nil {
+			return fmt.Errorf("cannot extract `%s`: %v", header.Name, err)
+		}
+		if header.Typeflag == tar.TypeDir {
+			dirs = append(dirs, header)
+		}
+	}
+
+	// children first, so that read-only dirs do not block setting their children
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return len(dirs[i].Name) > len(dirs[j].Name)
+	})
+	for _, header := range dirs {
+		// a later entry may have replaced the dir, or one of its parents, with a symlink
+		// whose target must not get the attributes
+		target, err := resolve(dest, header.Name)
+		if err != nil {
+			continue
+		}
+		if info, err := os.Lstat(target); err != nil || !info.IsDir() {
+			continue
+		}
+		if err := setAttributes(target, header); err != nil {
+			return fmt.Errorf("cannot extract `%s`: %v", header.Name, err)
+		}
+	}
+	return nil
+}
+
+// resolve joins an entry name to `dest`, refusing names which leave it and paths through symlinks
+func resolve(dest, name string) (string, error) {
+	// entries are relative to the archive root, `..` must not climb above it
+	cleaned := filepath.Clean(strings.TrimLeft(name, "/"))
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("entry `%s` leaves the archive", name)
+	}
+	target := filepath.Join(dest, cleaned)
+	// every existing parent must be a real dir
+	for parent := filepath.Dir(target); parent != dest && strings.HasPrefix(parent, dest+"/"); parent = filepath.Dir(parent) {
+		info, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("entry `%s` goes through symlink `%s`", name, strings.TrimPrefix(parent, dest))
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("entry `%s` goes through non-directory `%s`", name, strings.TrimPrefix(parent, dest))
+		}
+	}
+	return target, nil
+}
+
+func extractEntry(dest, target string, header *tar.Header, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	// an entry replaces what is there, except a dir replacing a dir
+	if info, err := os.Lstat(target); err == nil {
+		if !(info.IsDir() && header.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+	}
+
+	mode := uint32(header.Mode & 07777)
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(target, 0700); err != nil && !os.IsExist(err) {
+			return err
+		}
+		return nil
+	case tar.TypeReg, tar.TypeRegA:
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY|syscall.O_NOFOLLOW, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(file, r); err != nil {
+			_ = file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		// symlinks are never followed while extracting, so any target is safe to create
+		if err := os.Symlink(header.Linkname, target); err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		source, err := resolve(dest, header.Linkname)
+		if err != nil {
+			return err
+		}
+		if info, err := os.Lstat(source); err != nil {
+			return fmt.Errorf("cannot find link target `%s`: %v", header.Linkname, err)
+		} else if info.IsDir() {
+			return fmt.Errorf("link target `%s` is a dir", header.Linkname)
+		}
+		// a hardlink shares the attributes of its target
+		return os.Link(source, target)
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		switch header.Typeflag {
+		case tar.TypeChar:
+			mode |= syscall.S_IFCHR
+		case tar.TypeBlock:
+			mode |= syscall.S_IFBLK
+		default:
+			mode |= syscall.S_IFIFO
+		}
+		if err := syscall.Mknod(target, mode, subsystems.Mkdev(header.Devmajor, header.Devminor)); err != nil {
+			return err
+		}
+	case tar.TypeXGlobalHeader:
+		return nil
+	default:
+		return fmt.Errorf("unsupported entry type %q", header.Typeflag)
+	}
+	return setAttributes(target, header)
+}
+
+// setAttributes applies ownership, xattrs, mode and times, in this order as chown clears setuid bits
+func setAttributes(target string, header *tar.Header) error {
+	if os.Geteuid() == 0 {
+		if err := os.Lchown(target, header.Uid, header.Gid); err != nil {
+			return err
+		}
+	}
+	if header.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+	for key, value := range header.PAXRecords {
+		if !strings.HasPrefix(key, xattrPrefix) {
+			continue
+		}
+		if err := syscall.Setxattr(target, strings.TrimPrefix(key, xattrPrefix), []byte(value), 0); err != nil {
+			// xattrs outside the user namespace need privileges, the file itself is still usable
+			if err == syscall.EPERM || err == syscall.ENOTSUP {
+				continue
+			}
+			return fmt.Errorf("cannot set xattr `%s`: %v", key, err)
+		}
+	}
+	if err := os.Chmod(target, os.FileMode(header.Mode&0777)|modeBits(header.Mode)); err != nil {
+		return err
+	}
+	modTime := header.ModTime
+	accessTime := header.AccessTime
+	if accessTime.IsZero() {
+		accessTime = modTime
+	}
+	if modTime.IsZero() {
+		modTime, accessTime = time.Now(), time.Now()
+	}
+	return os.Chtimes(target, accessTime, modTime)
+}
+
+// modeBits converts the setuid, setgid and sticky bits of a tar mode
+func modeBits(mode int64) os.FileMode {
+	var bits os.FileMode
+	if mode&04000 != 0 {
+		bits |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		bits |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		bits |= os.ModeSticky
+	}
+	return bits
+}
@@ -0,0 +1,170 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type entry struct {
+	header *tar.Header
+	body   string
+}
+
+func file(name, body string) entry {
+	return entry{header: &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(body))}, body: body}
+}
+
+func dir(name string, mode int64) entry {
+	return entry{header: &tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: mode}}
+}
+
+func symlink(name, target string) entry {
+	return entry{header: &tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: target}}
+}
+
+func hardlink(name, target string) entry {
+	return entry{header: &tar.Header{Name: name, Typeflag: tar.TypeLink, Linkname: target}}
+}
+
+func tarball(t *testing.T, entries ...entry) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	w := tar.NewWriter(buf)
+	for _, e := range entries {
+		if err := w.WriteHeader(e.header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+// sandbox returns the extraction dir and a file next to it, which no archive may touch
+func sandbox(t *testing.T) (dest, outside string) {
+	root, err := ioutil.TempDir("", "archive-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(root)
+	})
+	outside = filepath.Join(root, "outside")
+	if err := ioutil.WriteFile(outside, []byte("host"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Unix(1000000000, 0)
+	if err := os.Chtimes(outside, past, past); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(root, "dest"), outside
+}
+
+func assertUntouched(t *testing.T, outside string) {
+	info, err := os.Stat(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode of outside file changed to %v", info.Mode())
+	}
+	if !info.ModTime().Equal(time.Unix(1000000000, 0)) {
+		t.Errorf("mtime of outside file changed to %v", info.ModTime())
+	}
+	content, err := ioutil.ReadFile(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "host" {
+		t.Errorf("outside file was overwritten with %q", content)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	dest, _ := sandbox(t)
+	err := Extract(tarball(t,
+		dir("d/", 0750),
+		file("d/f", "hello"),
+		hardlink("d/h", "d/f"),
+		symlink("d/s", "f"),
+	), dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dest, "d/h"))
+	if err != nil || string(content) != "hello" {
+		t.Errorf("hardlink reads %q, %v", content, err)
+	}
+	if target, err := os.Readlink(filepath.Join(dest, "d/s")); err != nil || target != "f" {
+		t.Errorf("symlink points at %q, %v", target, err)
+	}
+	if info, err := os.Stat(filepath.Join(dest, "d")); err != nil || info.Mode().Perm() != 0750 {
+		t.Errorf("dir mode is %v, %v", info.Mode(), err)
+	}
+}
+
+func TestExtractRejectsEscapes(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		entries func(outside string) []entry
+	}{
+		{"parent entry", func(string) []entry {
+			return []entry{file("../outside", "evil")}
+		}},
+		{"nested parent entry", func(string) []entry {
+			return []entry{dir("a/", 0755), file("a/../../outside", "evil")}
+		}},
+		{"absolute symlink parent", func(outside string) []entry {
+			return []entry{symlink("l", filepath.Dir(outside)), file("l/outside", "evil")}
+		}},
+		{"relative symlink parent", func(string) []entry {
+			return []entry{symlink("l", ".."), file("l/outside", "evil")}
+		}},
+		{"escaping hardlink", func(string) []entry {
+			return []entry{hardlink("h", "../outside")}
+		}},
+		{"hardlink through symlink", func(outside string) []entry {
+			return []entry{symlink("l", filepath.Dir(outside)), hardlink("h", "l/outside")}
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dest, outside := sandbox(t)
+			if err := Extract(tarball(t, test.entries(outside)...), dest); err == nil {
+				t.Error("extraction succeeded")
+			}
+			assertUntouched(t, outside)
+		})
+	}
+}
+
+func TestExtractAbsoluteHardlinkStaysInside(t *testing.T) {
+	dest, outside := sandbox(t)
+	// an absolute name is relative to the archive root, which has no such file
+	if err := Extract(tarball(t, hardlink("h", outside)), dest); err == nil {
+		t.Error("extraction succeeded")
+	}
+	assertUntouched(t, outside)
+}
+
+func TestExtractDirReplacedBySymlink(t *testing.T) {
+	dest, outside := sandbox(t)
+	// the attributes of `x/` are applied at the end, after `x` became a symlink
+	mode := dir("x/", 0777)
+	mode.header.ModTime = time.Unix(2000000000, 0)
+	mode.header.PAXRecords = map[string]string{xattrPrefix + "user.evil": "1"}
+	if err := Extract(tarball(t, mode, symlink("x", outside)), dest); err != nil {
+		t.Fatal(err)
+	}
+	assertUntouched(t, outside)
+	if _, err := os.Lstat(filepath.Join(dest, "x")); err != nil {
+		t.Fatal(err)
+	}
+}
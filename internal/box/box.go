@@ -0,0 +1,256 @@
+package box
+
+import (
+	"fmt"
+	"github.com/yqszxx/oreo-box/config"
+	"github.com/yqszxx/oreo-box/internal"
+	"github.com/yqszxx/oreo-box/internal/cgroup"
+	"github.com/yqszxx/oreo-box/internal/cgroup/subsystems"
+	"github.com/yqszxx/oreo-box/internal/events"
+	"github.com/yqszxx/oreo-box/internal/fileSystem"
+	"github.com/yqszxx/oreo-box/internal/image"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// NotFoundError is returned when the named box does not exist
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("no such box `%s`", e.Name)
+}
+
+// Detail is the box info together with its live state
+type Detail struct {
+	*internal.BoxInfo
+	Pids *subsystems.PidsStats `json:"pids,omitempty"`
+}
+
+// Get returns the info of a box
+func Get(boxName string) (*internal.BoxInfo, error) {
+	if boxName == "" || strings.Contains(boxName, "/") ||
+		!internal.Exist(path.Join(config.BoxDataPath, boxName), true) {
+		return nil, &NotFoundError{Name: boxName}
+	}
+	boxInfo, err := internal.GetBoxInfoByName(boxName)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get box %s info : %v", boxName, err)
+	}
+	return boxInfo, nil
+}
+
+// List returns the info of all boxes
+func List() ([]*internal.BoxInfo, error) {
+	return internal.GetAllBoxInfos()
+}
+
+// Inspect returns the details of a box
+func Inspect(boxName string) (*Detail, error) {
+	boxInfo, err := Get(boxName)
+	if err != nil {
+		return nil, err
+	}
+
+	detail := &Detail{BoxInfo: boxInfo}
+	if boxInfo.Status == internal.Running {
+		pidsStats, err := cgroup.NewCgroupManager(boxInfo.Id).PidsStats()
+		if err != nil {
+			log.Printf("cannot get pids stats of box `%s`: %v", boxName, err)
+		} else {
+			detail.Pids = pidsStats
+		}
+	}
+	return detail, nil
+}
+
+// stopTimeout is how long `Stop` waits for a box to exit after SIGTERM before it kills it
+const stopTimeout = 10 * time.Second
+
+// Stop kills the init process of a box and removes its cgroup, keeping its workspace and its network address
+func Stop(boxName string) error {
+	boxInfo, err := Get(boxName)
+	if err != nil {
+		return err
+	}
+
+	pid, err := strconv.Atoi(boxInfo.Pid)
+	if err != nil {
+		return fmt.Errorf("fail to conver pid from string to int: %v", err)
+	}
+
+	if internal.IsAlive(pid) {
+		if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+			return fmt.Errorf("fail to stop box `%s`: %v", boxName, err)
+		}
+		// init of a pid namespace ignores signals it has no handler for, except SIGKILL
+		if !waitExit(pid, stopTimeout) {
+			if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+				return fmt.Errorf("fail to stop box `%s`: %v", boxName, err)
+			}
+			if !waitExit(pid, stopTimeout) {
+				return fmt.Errorf("box `%s` did not exit", boxName)
+			}
+		}
+	}
+	if err := cgroup.NewCgroupManager(boxInfo.Id).Destroy(); err != nil {
+		return fmt.Errorf("cannot remove cgroup of box `%s`: %v", boxName, err)
+	}
+
+	boxInfo.Status = internal.Stopped
+	boxInfo.Pid = ""
+	if err := internal.WriteBoxInfo(boxInfo); err != nil {
+		return err
+	}
+	events.Log(events.TypeBox, "stop", boxInfo.Id, boxName, nil)
+
+	return nil
+}
+
+// Remove deletes the workspace and data of a box which is not running
+func Remove(boxName string) error {
+	boxInfo, err := Get(boxName)
+	if err != nil {
+		return err
+	}
+	if boxInfo.Status == internal.Running {
+		pid, err := strconv.Atoi(boxInfo.Pid)
+		if err != nil {
+			return fmt.Errorf("fail to conver pid from string to int : %v", err)
+		}
+		if internal.IsAlive(pid) {
+			return fmt.Errorf("couldn't remove running box")
+		}
+	}
+	if err := fileSystem.DeleteWorkSpace(boxInfo.Volume, boxName); err != nil {
+		return fmt.Errorf("cannot delete workspace of box `%s`: %v", boxName, err)
+	}
+	if err := deleteBoxInfo(boxName); err != nil {
+		return err
+	}
+	if err := releaseBox(boxInfo); err != nil {
+		log.Printf("Cannot release box `%s`: %v", boxName, err)
+	}
+	// dirs of images removed while the box existed were kept for it
+	if err := image.Release(boxInfo.Layers); err != nil {
+		log.Printf("Cannot release image dirs of box `%s`: %v", boxName, err)
+	}
+	events.Log(events.TypeBox, "remove", boxInfo.Id, boxName, nil)
+
+	return nil
+}
+
+// waitExit polls until process `pid` is gone, it tells whether it is within `timeout`
+func waitExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for internal.IsAlive(pid) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
+
+// Update merges `update` into the resource limits of a running box
+func Update(boxName string, update *subsystems.ResourceConfig) error {
+	boxInfo, err := Get(boxName)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(boxInfo.Pid)
+	if err != nil || boxInfo.Status != internal.Running || !internal.IsAlive(pid) {
+		return fmt.Errorf("box `%s` is not running", boxName)
+	}
+
+	resConf := &subsystems.ResourceConfig{}
+	if boxInfo.Resources != nil {
+		resConf = boxInfo.Resources
+	}
+	resConf.Merge(update)
+	if err := resConf.Validate(); err != nil {
+		return fmt.Errorf("invalid resource config for box `%s`: %v", boxName, err)
+	}
+
+	// device rules cannot be changed by `update`, leave the live allow list alone
+	applied := *resConf
+	applied.Devices = nil
+	if err := cgroup.NewCgroupManager(boxInfo.Id).Set(&applied); err != nil {
+		return fmt.Errorf("cgroup manager `set` failed for box `%s`: %v", boxName, err)
+	}
+
+	boxInfo.Resources = resConf
+	if err := internal.WriteBoxInfo(boxInfo); err != nil {
+		return err
+	}
+	events.Log(events.TypeBox, "update", boxInfo.Id, boxName, nil)
+	return nil
+}
+
+// Logs opens the output of a detached box
+func Logs(boxName string) (io.ReadCloser, error) {
+	if _, err := Get(boxName); err != nil {
+		return nil, err
+	}
+	logFileLocation := path.Join(config.BoxDataPath, boxName, config.LogFileName)
+	file, err := os.Open(logFileLocation)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open Log box file %s : %v", logFileLocation, err)
+	}
+	return file, nil
+}
+
+// Exec runs a command inside the namespaces of a running box and waits for it
+func Exec(boxName string, commandArray []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(commandArray) == 0 {
+		return fmt.Errorf("no command provided")
+	}
+	boxInfo, err := Get(boxName)
+	if err != nil {
+		return err
+	}
+	pid := boxInfo.Pid
+	if pid == "" {
+		return fmt.Errorf("box `%s` is not running", boxName)
+	}
+
+	cmdStr := strings.Join(commandArray, " ")
+	log.Printf("exec in box pid %s with command '%s'\n", pid, cmdStr)
+
+	self, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return fmt.Errorf("cannot get the location of `self`: %v", err)
+	}
+	stdinFile, closeStdin, err := stdinFile(stdin)
+	if err != nil {
+		return err
+	}
+	defer closeStdin()
+
+	cmd := exec.Command(self, "exec")
+	cmd.Stdin = stdinFile
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	boxEnvs, err := internal.GetEnvsByPid(pid)
+	if err != nil {
+		return err
+	}
+	// the nsenter constructor picks these up before the Go runtime starts
+	cmd.Env = append(os.Environ(), config.EnvExecPid+"="+pid, config.EnvExecCmd+"="+cmdStr)
+	cmd.Env = append(cmd.Env, boxEnvs...)
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cannot exec in box %s: %v", boxName, err)
+	}
+
+	return nil
+}
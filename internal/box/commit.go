@@ -0,0 +1,41 @@
+package box
+
+import (
+	"fmt"
+	"github.com/yqszxx/oreo-box/internal/fileSystem"
+	"github.com/yqszxx/oreo-box/internal/image"
+	"io"
+)
+
+// Commit creates image `imageName` from the image of a box and the changes made in the box
+func Commit(boxName, imageName string, options *image.CommitOptions) (*image.Metadata, error) {
+	boxInfo, err := Get(boxName)
+	if err != nil {
+		return nil, err
+	}
+	if boxInfo.Image == "" {
+		return nil, fmt.Errorf("box `%s` does not record its image", boxName)
+	}
+	if options == nil {
+		options = &image.CommitOptions{}
+	}
+	return image.Commit(boxInfo.Image, imageName, fileSystem.WritableLayerDir(boxName), "commit "+boxName, options)
+}
+
+// Export writes the merged filesystem of a box as a tarball
+func Export(boxName string, w io.Writer) error {
+	boxInfo, err := Get(boxName)
+	if err != nil {
+		return err
+	}
+	if boxInfo.Image == "" {
+		return fmt.Errorf("box `%s` does not record its image", boxName)
+	}
+	layerDirs := boxInfo.Layers
+	if len(layerDirs) == 0 {
+		if layerDirs, err = image.LayerDirs(boxInfo.Image); err != nil {
+			return err
+		}
+	}
+	return image.WriteFlattened(append([]string{fileSystem.WritableLayerDir(boxName)}, layerDirs...), w)
+}
@@ -0,0 +1,54 @@
+package box
+
+import (
+	"fmt"
+	"github.com/yqszxx/oreo-box/internal"
+	"github.com/yqszxx/oreo-box/internal/image"
+	"strings"
+)
+
+// Filter matches boxes whose fields equal one of the values given for each key,
+// keys are `id`, `name`, `status` and `ancestor`, the image a box was created from by name or digest
+type Filter map[string][]string
+
+func ParseFilter(filters []string) (Filter, error) {
+	filter := Filter{}
+	for _, f := range filters {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid filter `%s`, expected `key=value`", f)
+		}
+		switch kv[0] {
+		case "id", "name", "status", "ancestor":
+		default:
+			return nil, fmt.Errorf("unknown filter key `%s`", kv[0])
+		}
+		filter[kv[0]] = append(filter[kv[0]], kv[1])
+	}
+	return filter, nil
+}
+
+func (f Filter) Match(boxInfo *internal.BoxInfo) bool {
+	for key, values := range f {
+		matched := false
+		for _, value := range values {
+			switch key {
+			case "id":
+				matched = boxInfo.Id == value
+			case "name":
+				matched = boxInfo.Name == value
+			case "status":
+				matched = boxInfo.Status == value
+			case "ancestor":
+				matched = boxInfo.Image == value || image.MatchDigest(boxInfo.ImageDigest, value)
+			}
+			if matched {
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
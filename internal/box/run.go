@@ -0,0 +1,461 @@
+package box
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/yqszxx/oreo-box/config"
+	"github.com/yqszxx/oreo-box/internal"
+	"github.com/yqszxx/oreo-box/internal/cgroup"
+	"github.com/yqszxx/oreo-box/internal/cgroup/subsystems"
+	"github.com/yqszxx/oreo-box/internal/events"
+	"github.com/yqszxx/oreo-box/internal/fileSystem"
+	"github.com/yqszxx/oreo-box/internal/image"
+	"github.com/yqszxx/oreo-box/internal/network"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Spec describes a box to be created
+type Spec struct {
+	Image string `json:"image"`
+	// Args replace the Cmd of the image, Entrypoint replaces its Entrypoint unless nil
+	Args       []string `json:"args"`
+	Entrypoint []string `json:"entrypoint"`
+	// WorkingDir and User override those of the image
+	WorkingDir  string   `json:"workingDir"`
+	User        string   `json:"user"`
+	Name        string   `json:"name"`
+	Volume      string   `json:"volume"`
+	Env         []string `json:"env"`
+	Network     string   `json:"network"`
+	PortMapping []string `json:"portMapping"`
+	// Devices are created in the box in addition to `subsystems.DefaultDevices`
+	Devices []*subsystems.Device `json:"devices"`
+	// Resources.Devices are allowed in addition to `subsystems.DefaultDeviceRules` and the rules of `Devices`
+	Resources   *subsystems.ResourceConfig `json:"resources"`
+	Interactive bool                       `json:"interactive"`
+
+	// standard streams of an interactive box
+	Stdin  io.Reader `json:"-"`
+	Stdout io.Writer `json:"-"`
+	Stderr io.Writer `json:"-"`
+}
+
+// Run creates a box and starts it, an interactive box is waited for and removed once it exits
+func Run(spec *Spec) (*internal.BoxInfo, error) {
+	normalExit := false
+
+	resConf := &subsystems.ResourceConfig{}
+	if spec.Resources != nil {
+		copied := *spec.Resources
+		resConf = &copied
+	}
+	if resConf.PidsLimit == 0 {
+		resConf.PidsLimit = subsystems.DefaultPidsLimit
+	}
+	devices := append([]*subsystems.Device{}, subsystems.DefaultDevices...)
+	rules := append([]*subsystems.DeviceRule{}, subsystems.DefaultDeviceRules...)
+	for _, device := range spec.Devices {
+		devices = append(devices, device)
+		rule := device.DeviceRule
+		rules = append(rules, &rule)
+	}
+	resConf.Devices = append(rules, resConf.Devices...)
+	if err := resConf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid resource config: %v", err)
+	}
+
+	// boxes record the name the image is stored under, whichever tag they were created from
+	imageName, err := image.Resolve(spec.Image)
+	if err != nil {
+		return nil, err
+	}
+	imageMetadata, err := image.LoadMetadata(imageName)
+	if err != nil {
+		return nil, err
+	}
+	cmdArray, env, workingDir, user := applyImageConfig(spec, imageMetadata)
+	imageDigest := ""
+	if imageMetadata != nil {
+		imageDigest = imageMetadata.Digest
+	}
+	if len(cmdArray) == 0 {
+		return nil, fmt.Errorf("no command specified and image `%s` has no default command", imageName)
+	}
+	boxName := spec.Name
+	volume := spec.Volume
+	networkName := spec.Network
+
+	boxID := randStringBytes(10)
+	if boxName == "" {
+		boxName = boxID
+	}
+	if internal.Exist(path.Join(config.BoxDataPath, boxName), true) {
+		return nil, fmt.Errorf("box `%s` already exists", boxName)
+	}
+
+	// create pipe for sending command into box
+	readPipe, writePipe, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create new pipe: %v", err)
+	}
+	initCmd, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the location of `self`: %v", err)
+	}
+
+	initProcess := newInitProcess(initCmd, readPipe, env)
+
+	if spec.Interactive {
+		stdin, closeStdin, err := stdinFile(spec.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		defer closeStdin()
+		initProcess.Stdin = stdin
+		initProcess.Stdout = spec.Stdout
+		initProcess.Stderr = spec.Stderr
+	} else {
+		dataDir := path.Join(config.BoxDataPath, boxName)
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			return nil, fmt.Errorf("cannot create data dir `%s`: %v", dataDir, err)
+		}
+		logFilePath := path.Join(dataDir, config.LogFileName)
+		logFile, err := os.Create(logFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create log file `%s`: %v", logFilePath, err)
+		}
+		defer func() {
+			if err := logFile.Close(); err != nil {
+				panic(err)
+			}
+		}()
+		initProcess.Stdout = logFile
+	}
+
+	layerDirs, err := fileSystem.NewWorkSpace(volume, imageName, boxName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create new workspace: %v", err)
+	}
+	// cleanups run once `Run` has failed, they log what they cannot undo so that the error of `Run` is returned
+	defer func() {
+		if normalExit {
+			return
+		}
+		log.Println("Removing workspace...")
+		if err := fileSystem.DeleteWorkSpace(volume, boxName); err != nil {
+			// the data dir holds the mountpoint, it is kept unless unmounted
+			log.Printf("Cannot remove workspace of box `%s`: %v", boxName, err)
+			return
+		}
+		if err := deleteBoxInfo(boxName); err != nil {
+			log.Printf("Cannot remove box info of `%s`: %v", boxName, err)
+		}
+	}()
+	// use boxID as cgroup name
+	log.Printf("creating cgroup for %v\n", boxID)
+	cgroupManager := cgroup.NewCgroupManager(boxID)
+
+	defer func() {
+		if normalExit {
+			return
+		}
+		log.Println("Removing cgroup...")
+		if err := cgroupManager.Destroy(); err != nil {
+			log.Printf("Cannot remove cgroup of box `%s`: %v", boxName, err)
+		}
+	}()
+
+	initProcess.Dir = path.Join(config.BoxDataPath, boxName, config.MountPath)
+
+	if err := initProcess.Start(); err != nil {
+		return nil, fmt.Errorf("cannot start init process: %v", err)
+	}
+	defer func() {
+		if normalExit {
+			return
+		}
+		log.Println("Killing init process...")
+		// init may have exited already
+		if err := syscall.Kill(initProcess.Process.Pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+			log.Printf("Cannot kill init process of box `%s`: %v", boxName, err)
+		}
+		_ = initProcess.Wait()
+	}()
+	// the child owns the read end now
+	if err := readPipe.Close(); err != nil {
+		return nil, err
+	}
+
+	//record box info
+	boxInfo, err := recordBoxInfo(initProcess.Process.Pid, cmdArray, imageName, imageDigest, layerDirs, boxName, boxID, volume, networkName, resConf)
+	if err != nil {
+		return nil, fmt.Errorf("cannot record box info %v", err)
+	}
+
+	if err := cgroupManager.Set(resConf); err != nil {
+		return nil, fmt.Errorf("cgroup manager `set` failed with: %v", err)
+	}
+
+	if err := cgroupManager.Apply(initProcess.Process.Pid); err != nil {
+		return nil, fmt.Errorf("cgroup manager `apply` failed with: %v", err)
+	}
+
+	if networkName != "" {
+		err := connectNetwork(networkName, boxInfo, spec.PortMapping)
+		defer func() {
+			if normalExit {
+				return
+			}
+			if err := network.Disconnect(networkName, boxInfo); err != nil {
+				log.Printf("Cannot disconnect box `%s`: %v", boxName, err)
+			}
+		}()
+		if err != nil {
+			return nil, err
+		}
+		if err := internal.WriteBoxInfo(boxInfo); err != nil {
+			return nil, err
+		}
+	}
+
+	initConfig := &internal.InitConfig{
+		Args:    cmdArray,
+		Devices: devices,
+		Cwd:     workingDir,
+		User:    user,
+	}
+	if err := sendInitConfig(initConfig, writePipe); err != nil {
+		return nil, err
+	}
+
+	events.Log(events.TypeBox, "start", boxID, boxName, map[string]string{"image": imageName})
+
+	if spec.Interactive {
+		waitErr := initProcess.Wait()
+		events.Log(events.TypeBox, "die", boxID, boxName, nil)
+		if waitErr != nil {
+			return nil, fmt.Errorf("error waiting init process: %v", waitErr)
+		}
+
+		if err := fileSystem.DeleteWorkSpace(volume, boxName); err != nil {
+			return nil, fmt.Errorf("cannot delete workspace: %v", err)
+		}
+
+		if err := deleteBoxInfo(boxName); err != nil {
+			return nil, fmt.Errorf("cannot delete box info dir: %v", err)
+		}
+		if err := releaseBox(boxInfo); err != nil {
+			log.Printf("Cannot release box `%s`: %v", boxName, err)
+		}
+
+		log.Println("Interactive mode terminated successfully")
+		boxInfo.Status = internal.Exited
+	} else {
+		// what init was started with is kept so that the box can be started again once stopped
+		if err := writeStartConfig(boxName, &startConfig{Init: initConfig, Env: env, PortMapping: spec.PortMapping}); err != nil {
+			return nil, err
+		}
+		if err := startMonitor(initCmd, boxName); err != nil {
+			return nil, err
+		}
+		// reap the init process when the caller is long-running
+		go func() {
+			_ = initProcess.Wait()
+		}()
+	}
+
+	normalExit = true
+	return boxInfo, nil
+}
+
+// applyImageConfig merges the default config of a layered image into the spec,
+// images without metadata have no defaults
+func applyImageConfig(spec *Spec, metadata *image.Metadata) (args, env []string, workingDir, user string) {
+	imageConfig := image.Config{}
+	if metadata != nil {
+		imageConfig = metadata.Config
+	}
+
+	entrypoint := imageConfig.Entrypoint
+	cmd := imageConfig.Cmd
+	if spec.Entrypoint != nil {
+		// the cmd of the image is meant for its own entrypoint
+		entrypoint = spec.Entrypoint
+		cmd = nil
+	}
+	if len(spec.Args) > 0 {
+		cmd = spec.Args
+	}
+	args = append(append([]string{}, entrypoint...), cmd...)
+
+	// variables from the spec come last and take precedence
+	env = append(append([]string{}, imageConfig.Env...), spec.Env...)
+
+	workingDir = imageConfig.WorkingDir
+	if spec.WorkingDir != "" {
+		workingDir = spec.WorkingDir
+	}
+	user = imageConfig.User
+	if spec.User != "" {
+		user = spec.User
+	}
+	return args, env, workingDir, user
+}
+
+// newInitProcess returns the init process of a box in new namespaces, which reads its config from `readPipe`
+func newInitProcess(self string, readPipe *os.File, env []string) *exec.Cmd {
+	initProcess := exec.Command(self, "init")
+	initProcess.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS |
+			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
+	}
+	initProcess.ExtraFiles = []*os.File{readPipe}
+	initProcess.Env = append(os.Environ(), env...)
+	return initProcess
+}
+
+// connectNetwork connects the running box `boxInfo` to network `networkName`, with the address it was given before if any,
+// and records the address and the port mappings in `boxInfo`
+func connectNetwork(networkName string, boxInfo *internal.BoxInfo, portMapping []string) error {
+	if err := network.Init(); err != nil {
+		return err
+	}
+	endpointInfo := &internal.BoxInfo{
+		Id:          boxInfo.Id,
+		Pid:         boxInfo.Pid,
+		Name:        boxInfo.Name,
+		PortMapping: portMapping,
+		IPAddress:   boxInfo.IPAddress,
+	}
+	err := network.Connect(networkName, endpointInfo)
+	// the address is allocated even if the rest failed
+	boxInfo.IPAddress = endpointInfo.IPAddress
+	boxInfo.PortMapping = portMapping
+	if err != nil {
+		return fmt.Errorf("cannot connect network: %v", err)
+	}
+	return nil
+}
+
+// releaseBox removes the cgroup of a box whose processes are gone and releases its network address
+func releaseBox(boxInfo *internal.BoxInfo) error {
+	if err := cgroup.NewCgroupManager(boxInfo.Id).Destroy(); err != nil {
+		return err
+	}
+	if boxInfo.Network == "" {
+		return nil
+	}
+	if err := network.Init(); err != nil {
+		return err
+	}
+	return network.Disconnect(boxInfo.Network, boxInfo)
+}
+
+// stdinFile returns `r` as a file which can be handed to a child process, copying into a pipe if necessary
+func stdinFile(r io.Reader) (*os.File, func(), error) {
+	if r == nil {
+		return nil, func() {}, nil
+	}
+	if f, ok := r.(*os.File); ok {
+		return f, func() {}, nil
+	}
+	readPipe, writePipe, err := os.Pipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot create stdin pipe: %v", err)
+	}
+	go func() {
+		_, _ = io.Copy(writePipe, r)
+		_ = writePipe.Close()
+	}()
+	return readPipe, func() {
+		_ = readPipe.Close()
+		_ = writePipe.Close()
+	}, nil
+}
+
+// startMonitor starts a process outliving `run` which records the death and OOM kills of a detached box
+func startMonitor(self, boxName string) error {
+	monitorProcess := exec.Command(self, "monitor", boxName)
+	monitorProcess.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+	if err := monitorProcess.Start(); err != nil {
+		return fmt.Errorf("cannot start monitor process: %v", err)
+	}
+	// a one-shot caller exits right away and leaves the monitor to init, the daemon has to reap it
+	go func() {
+		_ = monitorProcess.Wait()
+	}()
+	return nil
+}
+
+func sendInitConfig(initConfig *internal.InitConfig, writePipe *os.File) error {
+	log.Printf("command all is %s", strings.Join(initConfig.Args, " "))
+	configBytes, err := json.Marshal(initConfig)
+	if err != nil {
+		return err
+	}
+	if _, err := writePipe.Write(configBytes); err != nil {
+		return err
+	}
+	if err := writePipe.Close(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func recordBoxInfo(boxPID int, commandArray []string, imageName, imageDigest string, layerDirs []string, boxName, id, volume, networkName string, resConf *subsystems.ResourceConfig) (*internal.BoxInfo, error) {
+	createTime := time.Now().Format("2006-01-02 15:04:05")
+	command := strings.Join(commandArray, "")
+	BoxInfo := &internal.BoxInfo{
+		Id:          id,
+		Pid:         strconv.Itoa(boxPID),
+		Command:     command,
+		Image:       imageName,
+		ImageDigest: imageDigest,
+		Layers:      layerDirs,
+		CreatedTime: createTime,
+		Status:      internal.Running,
+		Name:        boxName,
+		Volume:      volume,
+		Network:     networkName,
+		Resources:   resConf,
+	}
+
+	dataDir := path.Join(config.BoxDataPath, boxName)
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := internal.WriteBoxInfo(BoxInfo); err != nil {
+		return nil, err
+	}
+	return BoxInfo, nil
+}
+
+func deleteBoxInfo(boxName string) error {
+	dataDir := path.Join(config.BoxDataPath, boxName)
+	if err := os.RemoveAll(dataDir); err != nil {
+		return fmt.Errorf("cannot remove dir %s : %v", dataDir, err)
+	}
+
+	return nil
+}
+
+func randStringBytes(n int) string {
+	letterBytes := "1234567890"
+	rand.Seed(time.Now().UnixNano())
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+	}
+	return string(b)
+}
@@ -0,0 +1,157 @@
+package box
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/yqszxx/oreo-box/config"
+	"github.com/yqszxx/oreo-box/internal"
+	"github.com/yqszxx/oreo-box/internal/cgroup"
+	"github.com/yqszxx/oreo-box/internal/cgroup/subsystems"
+	"github.com/yqszxx/oreo-box/internal/events"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"syscall"
+)
+
+// startConfig is what the init process of a detached box was started with
+type startConfig struct {
+	Init        *internal.InitConfig `json:"init"`
+	Env         []string             `json:"env"`
+	PortMapping []string             `json:"portMapping"`
+}
+
+func startConfigPath(boxName string) string {
+	return path.Join(config.BoxDataPath, boxName, config.StartFileName)
+}
+
+func writeStartConfig(boxName string, startConf *startConfig) error {
+	content, err := json.Marshal(startConf)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(startConfigPath(boxName), content, 0644); err != nil {
+		return fmt.Errorf("cannot write start config of box `%s`: %v", boxName, err)
+	}
+	return nil
+}
+
+func readStartConfig(boxName string) (*startConfig, error) {
+	content, err := ioutil.ReadFile(startConfigPath(boxName))
+	if os.IsNotExist(err) {
+		// interactive boxes are removed once they exit
+		return nil, fmt.Errorf("box `%s` cannot be started again", boxName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read start config of box `%s`: %v", boxName, err)
+	}
+	startConf := &startConfig{}
+	if err := json.Unmarshal(content, startConf); err != nil {
+		return nil, fmt.Errorf("cannot parse start config of box `%s`: %v", boxName, err)
+	}
+	return startConf, nil
+}
+
+// Start starts a detached box which is stopped or has exited again, in the workspace, cgroup and network
+// it was created with, its output is appended to its log
+func Start(boxName string) (*internal.BoxInfo, error) {
+	boxInfo, err := Get(boxName)
+	if err != nil {
+		return nil, err
+	}
+	if pid, err := strconv.Atoi(boxInfo.Pid); err == nil && boxInfo.Status == internal.Running && internal.IsAlive(pid) {
+		return nil, fmt.Errorf("box `%s` is already running", boxName)
+	}
+	startConf, err := readStartConfig(boxName)
+	if err != nil {
+		return nil, err
+	}
+
+	readPipe, writePipe, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create new pipe: %v", err)
+	}
+	defer func() {
+		// sending the init config closes it already
+		_ = writePipe.Close()
+	}()
+	initCmd, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		_ = readPipe.Close()
+		return nil, fmt.Errorf("cannot get the location of `self`: %v", err)
+	}
+	initProcess := newInitProcess(initCmd, readPipe, startConf.Env)
+
+	dataDir := path.Join(config.BoxDataPath, boxName)
+	logFilePath := path.Join(dataDir, config.LogFileName)
+	logFile, err := os.OpenFile(logFilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		_ = readPipe.Close()
+		return nil, fmt.Errorf("cannot open log file `%s`: %v", logFilePath, err)
+	}
+	defer func() {
+		if err := logFile.Close(); err != nil {
+			log.Printf("Cannot close log file of box `%s`: %v", boxName, err)
+		}
+	}()
+	initProcess.Stdout = logFile
+	initProcess.Dir = path.Join(dataDir, config.MountPath)
+
+	err = initProcess.Start()
+	// the child owns the read end now
+	_ = readPipe.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cannot start init process: %v", err)
+	}
+	started := false
+	defer func() {
+		if started {
+			return
+		}
+		log.Println("Killing init process...")
+		if err := syscall.Kill(initProcess.Process.Pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+			log.Printf("Cannot kill init process of box `%s`: %v", boxName, err)
+		}
+		_ = initProcess.Wait()
+	}()
+	boxInfo.Pid = strconv.Itoa(initProcess.Process.Pid)
+
+	resConf := boxInfo.Resources
+	if resConf == nil {
+		resConf = &subsystems.ResourceConfig{PidsLimit: subsystems.DefaultPidsLimit}
+	}
+	cgroupManager := cgroup.NewCgroupManager(boxInfo.Id)
+	if err := cgroupManager.Set(resConf); err != nil {
+		return nil, fmt.Errorf("cgroup manager `set` failed with: %v", err)
+	}
+	if err := cgroupManager.Apply(initProcess.Process.Pid); err != nil {
+		return nil, fmt.Errorf("cgroup manager `apply` failed with: %v", err)
+	}
+	if boxInfo.Network != "" {
+		if err := connectNetwork(boxInfo.Network, boxInfo, startConf.PortMapping); err != nil {
+			return nil, err
+		}
+	}
+	if err := sendInitConfig(startConf.Init, writePipe); err != nil {
+		return nil, err
+	}
+
+	// the monitor watches the pid recorded in the info
+	boxInfo.Status = internal.Running
+	if err := internal.WriteBoxInfo(boxInfo); err != nil {
+		return nil, err
+	}
+	if err := startMonitor(initCmd, boxName); err != nil {
+		return nil, err
+	}
+	// reap the init process when the caller is long-running
+	go func() {
+		_ = initProcess.Wait()
+	}()
+	started = true
+
+	events.Log(events.TypeBox, "start", boxInfo.Id, boxName, map[string]string{"image": boxInfo.Image})
+	return boxInfo, nil
+}
@@ -35,10 +35,45 @@ func (c *CgroupManager) Set(res *subsystems.ResourceConfig) error {
 }
 
 func (c *CgroupManager) Destroy() error {
+	// the subsystems all resolve to the same dir in cgroup v2, it is removed once
+	if subsystems.IsCgroup2UnifiedMode() {
+		if err := subsystems.RemoveUnifiedCgroup(c.Path); err != nil {
+			return fmt.Errorf("remove cgroup fail %v", err)
+		}
+		return nil
+	}
 	for _, subSysIns := range subsystems.SubsystemsIns {
+		// comounted subsystems share a dir, and the cgroup of a stopped box is gone already
+		if _, err := subsystems.GetCgroupPath(subSysIns.Name(), c.Path, false); err != nil {
+			continue
+		}
 		if err := subSysIns.Remove(c.Path); err != nil {
 			return fmt.Errorf("remove cgroup fail %v", err)
 		}
 	}
 	return nil
 }
+
+func (c *CgroupManager) PidsStats() (*subsystems.PidsStats, error) {
+	return (&subsystems.PidsSubSystem{}).Stats(c.Path)
+}
+
+func (c *CgroupManager) GetStats() (*subsystems.Stats, error) {
+	stats := &subsystems.Stats{}
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		if reader, ok := subSysIns.(subsystems.StatsReader); ok {
+			if err := reader.GetStats(c.Path, stats); err != nil {
+				return nil, fmt.Errorf("cannot get stats of subsystem %s: %v", subSysIns.Name(), err)
+			}
+		}
+	}
+	return stats, nil
+}
+
+func (c *CgroupManager) GetPids() ([]int, error) {
+	return (&subsystems.PidsSubSystem{}).Procs(c.Path)
+}
+
+func (c *CgroupManager) OomKillCount() (uint64, error) {
+	return (&subsystems.MemorySubSystem{}).OomKillCount(c.Path)
+}
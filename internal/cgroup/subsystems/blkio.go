@@ -0,0 +1,165 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	MinBlkioWeight = 10
+	MaxBlkioWeight = 1000
+)
+
+// ThrottleDevice limits the bytes or operations per second on a block device
+type ThrottleDevice struct {
+	Major int64  `json:"major"`
+	Minor int64  `json:"minor"`
+	Rate  uint64 `json:"rate"`
+}
+
+func (t *ThrottleDevice) String() string {
+	return fmt.Sprintf("%d:%d %d", t.Major, t.Minor, t.Rate)
+}
+
+// ParseThrottleDevice parses `device-path:rate`, where rate is a size for bps limits and a number for iops limits
+func ParseThrottleDevice(s string, isBps bool) (*ThrottleDevice, error) {
+	sep := strings.LastIndex(s, ":")
+	if sep <= 0 || sep == len(s)-1 {
+		return nil, fmt.Errorf("invalid throttle `%s`, expected `device-path:rate`", s)
+	}
+	devicePath, rateStr := s[:sep], s[sep+1:]
+
+	var rate uint64
+	if isBps {
+		bytes, err := ParseBytes(rateStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate in `%s`: %v", s, err)
+		}
+		rate = uint64(bytes)
+	} else {
+		var err error
+		if rate, err = strconv.ParseUint(rateStr, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid rate in `%s`: %v", s, err)
+		}
+	}
+	if rate == 0 {
+		return nil, fmt.Errorf("invalid rate in `%s`: must be greater than 0", s)
+	}
+
+	major, minor, err := blockDeviceNumbers(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	return &ThrottleDevice{Major: major, Minor: minor, Rate: rate}, nil
+}
+
+// blockDeviceNumbers returns major:minor of a whole block device known by the kernel
+func blockDeviceNumbers(devicePath string) (int64, int64, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(devicePath, &stat); err != nil {
+		return 0, 0, fmt.Errorf("cannot stat device `%s`: %v", devicePath, err)
+	}
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return 0, 0, fmt.Errorf("`%s` is not a block device", devicePath)
+	}
+	major, minor := int64(Major(stat.Rdev)), int64(Minor(stat.Rdev))
+
+	sysPath := fmt.Sprintf("/sys/dev/block/%d:%d", major, minor)
+	if _, err := os.Stat(sysPath); err != nil {
+		return 0, 0, fmt.Errorf("block device %d:%d of `%s` is unknown to the kernel: %v", major, minor, devicePath, err)
+	}
+	// the kernel only throttles whole disks
+	if _, err := os.Stat(path.Join(sysPath, "partition")); err == nil {
+		return 0, 0, fmt.Errorf("`%s` is a partition, use the whole disk instead", devicePath)
+	}
+	return major, minor, nil
+}
+
+type BlkioSubSystem struct {
+}
+
+func (s *BlkioSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+		if IsCgroup2UnifiedMode() {
+			return s.setUnified(subsysCgroupPath, res)
+		}
+
+		if res.BlkioWeight != 0 {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "blkio.weight"), []byte(strconv.Itoa(int(res.BlkioWeight))), 0644); err != nil {
+				return fmt.Errorf("set cgroup blkio.weight fail %v", err)
+			}
+		}
+
+		throttles := map[string][]*ThrottleDevice{
+			"blkio.throttle.read_bps_device":   res.BlkioDeviceReadBps,
+			"blkio.throttle.write_bps_device":  res.BlkioDeviceWriteBps,
+			"blkio.throttle.read_iops_device":  res.BlkioDeviceReadIOps,
+			"blkio.throttle.write_iops_device": res.BlkioDeviceWriteIOps,
+		}
+		for file, devices := range throttles {
+			for _, device := range devices {
+				if err := ioutil.WriteFile(path.Join(subsysCgroupPath, file), []byte(device.String()), 0644); err != nil {
+					return fmt.Errorf("set cgroup %s fail %v", file, err)
+				}
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+// setUnified writes the settings to `io.weight` and `io.max` of cgroup v2
+func (s *BlkioSubSystem) setUnified(subsysCgroupPath string, res *ResourceConfig) error {
+	if res.BlkioWeight != 0 {
+		// map [10, 1000] of blkio.weight onto [1, 10000] of io.weight
+		weight := 1 + (uint64(res.BlkioWeight)-MinBlkioWeight)*9999/(MaxBlkioWeight-MinBlkioWeight)
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "io.weight"), []byte(fmt.Sprintf("default %d", weight)), 0644); err != nil {
+			return fmt.Errorf("set cgroup io.weight fail %v", err)
+		}
+	}
+
+	throttles := map[string][]*ThrottleDevice{
+		"rbps":  res.BlkioDeviceReadBps,
+		"wbps":  res.BlkioDeviceWriteBps,
+		"riops": res.BlkioDeviceReadIOps,
+		"wiops": res.BlkioDeviceWriteIOps,
+	}
+	for key, devices := range throttles {
+		for _, device := range devices {
+			limit := fmt.Sprintf("%d:%d %s=%d", device.Major, device.Minor, key, device.Rate)
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "io.max"), []byte(limit), 0644); err != nil {
+				return fmt.Errorf("set cgroup io.max `%s` fail %v", limit, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *BlkioSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+func (s *BlkioSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, procsFileName()), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *BlkioSubSystem) Name() string {
+	return "blkio"
+}
@@ -0,0 +1,3 @@
+package subsystems
+
+const sysBPF = 280
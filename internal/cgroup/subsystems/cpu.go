@@ -8,19 +8,40 @@ import (
 	"strconv"
 )
 
+const (
+	MinCpuShare = 2
+	MaxCpuShare = 262144
+
+	// DefaultCpuPeriodUs is the CFS period used when the limit is given with `--cpus`
+	DefaultCpuPeriodUs = 100000
+	MinCpuPeriodUs     = 1000
+	MaxCpuPeriodUs     = 1000000
+	MinCpuQuotaUs      = 1000
+)
+
 type CpuSubSystem struct {
 }
 
 func (s *CpuSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
-		if res.CpuShare != "" {
-			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.shares"), []byte(res.CpuShare), 0644); err != nil {
+		if IsCgroup2UnifiedMode() {
+			return s.setUnified(subsysCgroupPath, res)
+		}
+
+		if res.CpuShare != 0 {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.shares"), []byte(strconv.FormatUint(res.CpuShare, 10)), 0644); err != nil {
 				return fmt.Errorf("set cgroup cpu share fail %v", err)
 			}
 		}
 
-		if res.CpuQuotaUs != "" {
-			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_quota_us"), []byte(res.CpuQuotaUs), 0644); err != nil {
+		if res.CpuPeriodUs != 0 {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_period_us"), []byte(strconv.FormatUint(res.CpuPeriodUs, 10)), 0644); err != nil {
+				return fmt.Errorf("set cgroup cpu.cfs_period_us fail %v", err)
+			}
+		}
+
+		if res.CpuQuotaUs != 0 {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_quota_us"), []byte(strconv.FormatInt(res.CpuQuotaUs, 10)), 0644); err != nil {
 				return fmt.Errorf("set cgroup cpu.cfs_quota_us fail %v", err)
 			}
 		}
@@ -31,6 +52,32 @@ func (s *CpuSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	}
 }
 
+// setUnified writes the settings to `cpu.weight` and `cpu.max` of cgroup v2
+func (s *CpuSubSystem) setUnified(subsysCgroupPath string, res *ResourceConfig) error {
+	if res.CpuShare != 0 {
+		// map [2, 262144] of cpu.shares onto [1, 10000] of cpu.weight
+		weight := 1 + (res.CpuShare-MinCpuShare)*9999/(MaxCpuShare-MinCpuShare)
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.weight"), []byte(strconv.FormatUint(weight, 10)), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpu.weight fail %v", err)
+		}
+	}
+
+	if res.CpuQuotaUs != 0 || res.CpuPeriodUs != 0 {
+		quota := "max"
+		if res.CpuQuotaUs > 0 {
+			quota = strconv.FormatInt(res.CpuQuotaUs, 10)
+		}
+		period := res.CpuPeriodUs
+		if period == 0 {
+			period = DefaultCpuPeriodUs
+		}
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.max"), []byte(fmt.Sprintf("%s %d", quota, period)), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpu.max fail %v", err)
+		}
+	}
+	return nil
+}
+
 func (s *CpuSubSystem) Remove(cgroupPath string) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
 		return os.RemoveAll(subsysCgroupPath)
@@ -41,7 +88,7 @@ func (s *CpuSubSystem) Remove(cgroupPath string) error {
 
 func (s *CpuSubSystem) Apply(cgroupPath string, pid int) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, procsFileName()), []byte(strconv.Itoa(pid)), 0644); err != nil {
 			return fmt.Errorf("set cgroup proc fail %v", err)
 		}
 		return nil
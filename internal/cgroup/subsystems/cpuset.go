@@ -6,6 +6,12 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
+)
+
+const (
+	onlineCpusFile  = "/sys/devices/system/cpu/online"
+	onlineNodesFile = "/sys/devices/system/node/online"
 )
 
 type CpusetSubSystem struct {
@@ -18,7 +24,7 @@ func (s *CpusetSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpuset.cpus"), []byte(res.CpuSetCpus), 0644); err != nil {
 				return fmt.Errorf("set cgroup cpuset.cpus fail %v", err)
 			}
-		} else {
+		} else if isCpusetEmpty(subsysCgroupPath, "cpuset.cpus") {
 			ResetValue(&res.CpuSetCpus, "0")
 			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpuset.cpus"), []byte(res.CpuSetCpus), 0644); err != nil {
 				return fmt.Errorf("set cgroup cpuset.cpus fail %v", err)
@@ -29,7 +35,7 @@ func (s *CpusetSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpuset.mems"), []byte(res.CpuSetMems), 0644); err != nil {
 				return fmt.Errorf("set cgroup cpuset.mems fail %v", err)
 			}
-		} else {
+		} else if isCpusetEmpty(subsysCgroupPath, "cpuset.mems") {
 			ResetValue(&res.CpuSetMems, "0")
 			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpuset.mems"), []byte(res.CpuSetMems), 0644); err != nil {
 				return fmt.Errorf("set cgroup cpuset.mems fail %v", err)
@@ -52,7 +58,7 @@ func (s *CpusetSubSystem) Remove(cgroupPath string) error {
 
 func (s *CpusetSubSystem) Apply(cgroupPath string, pid int) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, procsFileName()), []byte(strconv.Itoa(pid)), 0644); err != nil {
 			return fmt.Errorf("set cgroup proc fail %v", err)
 		}
 		return nil
@@ -65,6 +71,16 @@ func (s *CpusetSubSystem) Name() string {
 	return "cpuset"
 }
 
+// isCpusetEmpty tells whether a cgroup v1 cpuset still needs a value before tasks can join it,
+// cgroup v2 inherits the parent's value instead
+func isCpusetEmpty(subsysCgroupPath, file string) bool {
+	if IsCgroup2UnifiedMode() {
+		return false
+	}
+	value, err := readCgroupFile(subsysCgroupPath, file)
+	return err != nil || value == ""
+}
+
 func ResetValue(s *string, newValue string) {
 	sByte := []byte(*s)
 	for i := 0; i < len(sByte); i++ {
@@ -72,3 +88,56 @@ func ResetValue(s *string, newValue string) {
 	}
 	*s = newValue
 }
+
+// ParseCpuList parses the list format used by cpusets, e.g. `0-3,6`
+func ParseCpuList(list string) (map[int]bool, error) {
+	ids := map[int]bool{}
+	for _, item := range strings.Split(strings.TrimSpace(list), ",") {
+		bounds := strings.SplitN(item, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid cpu list `%s`", list)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return nil, fmt.Errorf("invalid cpu list `%s`", list)
+			}
+		}
+		for id := first; id <= last; id++ {
+			ids[id] = true
+		}
+	}
+	return ids, nil
+}
+
+// OnlineCpus returns the ids of CPUs the kernel can schedule on
+func OnlineCpus() (map[int]bool, error) {
+	return readOnlineList(onlineCpusFile)
+}
+
+func readOnlineList(file string) (map[int]bool, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", file, err)
+	}
+	return ParseCpuList(string(content))
+}
+
+// validateOnline checks that every id in `list` is present in the online list `onlineFile`
+func validateOnline(list, onlineFile, kind string) error {
+	requested, err := ParseCpuList(list)
+	if err != nil {
+		return err
+	}
+	online, err := readOnlineList(onlineFile)
+	if err != nil {
+		return err
+	}
+	for id := range requested {
+		if !online[id] {
+			return fmt.Errorf("%s %d in `%s` is not online", kind, id, list)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	BlockDevice = "b"
+	CharDevice  = "c"
+	AllDevices  = "a"
+
+	// Wildcard matches any major or minor number
+	Wildcard = -1
+)
+
+// DeviceRule is an entry of the device access list, written as `type major:minor access`, e.g. `c 1:3 rwm`
+type DeviceRule struct {
+	Type   string `json:"type"`
+	Major  int64  `json:"major"`
+	Minor  int64  `json:"minor"`
+	Access string `json:"access"`
+	Allow  bool   `json:"allow"`
+}
+
+// Device is a device node created inside the box, together with the rule allowing access to it
+type Device struct {
+	DeviceRule
+	Path     string      `json:"path"`
+	FileMode os.FileMode `json:"fileMode"`
+	Uid      uint32      `json:"uid"`
+	Gid      uint32      `json:"gid"`
+}
+
+// DefaultDevices are created in `/dev` of every box
+var DefaultDevices = []*Device{
+	newCharDevice("/dev/null", 1, 3),
+	newCharDevice("/dev/zero", 1, 5),
+	newCharDevice("/dev/full", 1, 7),
+	newCharDevice("/dev/random", 1, 8),
+	newCharDevice("/dev/urandom", 1, 9),
+	newCharDevice("/dev/tty", 5, 0),
+}
+
+// DefaultDeviceRules is the allow list applied to every box, everything else is denied
+var DefaultDeviceRules = []*DeviceRule{
+	// allow mknod of any device, access is still checked when opening it
+	{Type: CharDevice, Major: Wildcard, Minor: Wildcard, Access: "m", Allow: true},
+	{Type: BlockDevice, Major: Wildcard, Minor: Wildcard, Access: "m", Allow: true},
+	// /dev/ptmx and /dev/pts/*
+	{Type: CharDevice, Major: 5, Minor: 2, Access: "rwm", Allow: true},
+	{Type: CharDevice, Major: 136, Minor: Wildcard, Access: "rwm", Allow: true},
+}
+
+func init() {
+	for _, device := range DefaultDevices {
+		rule := device.DeviceRule
+		DefaultDeviceRules = append(DefaultDeviceRules, &rule)
+	}
+}
+
+func newCharDevice(path string, major, minor int64) *Device {
+	return &Device{
+		DeviceRule: DeviceRule{
+			Type:   CharDevice,
+			Major:  major,
+			Minor:  minor,
+			Access: "rwm",
+			Allow:  true,
+		},
+		Path:     path,
+		FileMode: 0666,
+	}
+}
+
+func (r *DeviceRule) String() string {
+	return fmt.Sprintf("%s %s:%s %s", r.Type, deviceNumberString(r.Major), deviceNumberString(r.Minor), r.Access)
+}
+
+func deviceNumberString(n int64) string {
+	if n == Wildcard {
+		return "*"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func parseDeviceNumber(s string) (int64, error) {
+	if s == "*" {
+		return Wildcard, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid device number `%s`", s)
+	}
+	return n, nil
+}
+
+func validateAccess(access string) error {
+	if access == "" {
+		return fmt.Errorf("empty access")
+	}
+	for _, c := range access {
+		if !strings.ContainsRune("rwm", c) {
+			return fmt.Errorf("invalid access `%s`, must be a combination of `rwm`", access)
+		}
+	}
+	return nil
+}
+
+// ParseDeviceRule parses an allow rule like `c 1:3 rwm`, `b 8:* r` or `a`
+func ParseDeviceRule(s string) (*DeviceRule, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 1 && fields[0] == AllDevices {
+		return &DeviceRule{Type: AllDevices, Major: Wildcard, Minor: Wildcard, Access: "rwm", Allow: true}, nil
+	}
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("invalid device rule `%s`, expected `type major:minor access`", s)
+	}
+
+	rule := &DeviceRule{Type: fields[0], Access: fields[2], Allow: true}
+	if rule.Type != CharDevice && rule.Type != BlockDevice && rule.Type != AllDevices {
+		return nil, fmt.Errorf("invalid device type `%s` in rule `%s`", rule.Type, s)
+	}
+
+	numbers := strings.Split(fields[1], ":")
+	if len(numbers) != 2 {
+		return nil, fmt.Errorf("invalid device numbers `%s` in rule `%s`", fields[1], s)
+	}
+	var err error
+	if rule.Major, err = parseDeviceNumber(numbers[0]); err != nil {
+		return nil, fmt.Errorf("invalid rule `%s`: %v", s, err)
+	}
+	if rule.Minor, err = parseDeviceNumber(numbers[1]); err != nil {
+		return nil, fmt.Errorf("invalid rule `%s`: %v", s, err)
+	}
+
+	if err := validateAccess(rule.Access); err != nil {
+		return nil, fmt.Errorf("invalid rule `%s`: %v", s, err)
+	}
+	return rule, nil
+}
+
+// ParseDevice parses a device mapping like `/dev/sdc[:/dev/xvdc[:rwm]]` against the device node on the host
+func ParseDevice(s string) (*Device, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return nil, fmt.Errorf("invalid device `%s`, expected `host-path[:box-path[:access]]`", s)
+	}
+	hostPath := parts[0]
+	boxPath := hostPath
+	access := "rwm"
+	if len(parts) > 1 && parts[1] != "" {
+		boxPath = parts[1]
+	}
+	if len(parts) > 2 {
+		access = parts[2]
+	}
+	if err := validateAccess(access); err != nil {
+		return nil, fmt.Errorf("invalid device `%s`: %v", s, err)
+	}
+	if !path.IsAbs(boxPath) {
+		return nil, fmt.Errorf("invalid device `%s`: path inside box must be absolute", s)
+	}
+
+	device, err := DeviceFromPath(hostPath, access)
+	if err != nil {
+		return nil, err
+	}
+	device.Path = boxPath
+	return device, nil
+}
+
+// DeviceFromPath reads type, numbers and permissions of the device node at `devicePath`
+func DeviceFromPath(devicePath, access string) (*Device, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(devicePath, &stat); err != nil {
+		return nil, fmt.Errorf("cannot stat device `%s`: %v", devicePath, err)
+	}
+
+	var deviceType string
+	switch stat.Mode & syscall.S_IFMT {
+	case syscall.S_IFCHR:
+		deviceType = CharDevice
+	case syscall.S_IFBLK:
+		deviceType = BlockDevice
+	default:
+		return nil, fmt.Errorf("`%s` is not a device node", devicePath)
+	}
+
+	return &Device{
+		DeviceRule: DeviceRule{
+			Type:   deviceType,
+			Major:  int64(Major(stat.Rdev)),
+			Minor:  int64(Minor(stat.Rdev)),
+			Access: access,
+			Allow:  true,
+		},
+		Path:     devicePath,
+		FileMode: os.FileMode(stat.Mode & 0777),
+		Uid:      stat.Uid,
+		Gid:      stat.Gid,
+	}, nil
+}
+
+func Major(dev uint64) uint64 {
+	return ((dev >> 8) & 0xfff) | ((dev >> 32) & 0xfffff000)
+}
+
+func Minor(dev uint64) uint64 {
+	return (dev & 0xff) | ((dev >> 12) & 0xffffff00)
+}
+
+func Mkdev(major, minor int64) int {
+	return int((minor & 0xff) | ((major & 0xfff) << 8) | ((minor &^ 0xff) << 12) | ((major &^ 0xfff) << 32))
+}
+
+type DevicesSubSystem struct {
+}
+
+func (s *DevicesSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if res.Devices == nil {
+		return nil
+	}
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+		if IsCgroup2UnifiedMode() {
+			if err := attachDeviceFilter(subsysCgroupPath, res.Devices); err != nil {
+				return fmt.Errorf("set cgroup device filter fail %v", err)
+			}
+			return nil
+		}
+
+		// deny everything first, then open up the allow list
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "devices.deny"), []byte(AllDevices), 0644); err != nil {
+			return fmt.Errorf("set cgroup devices.deny fail %v", err)
+		}
+		for _, rule := range res.Devices {
+			file := "devices.allow"
+			if !rule.Allow {
+				file = "devices.deny"
+			}
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, file), []byte(rule.String()), 0644); err != nil {
+				return fmt.Errorf("set cgroup %s `%s` fail %v", file, rule, err)
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *DevicesSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+func (s *DevicesSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, procsFileName()), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *DevicesSubSystem) Name() string {
+	return "devices"
+}
@@ -0,0 +1,218 @@
+package subsystems
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// cgroup v2 has no `devices.allow`, device access is checked by an eBPF program attached to the cgroup
+
+const (
+	bpfProgLoad   = 5
+	bpfProgAttach = 8
+
+	bpfProgTypeCgroupDevice = 15
+	bpfCgroupDevice         = 6
+
+	// access type and device type in `struct bpf_cgroup_dev_ctx`
+	bpfDevcgAccMknod = 1
+	bpfDevcgAccRead  = 2
+	bpfDevcgAccWrite = 4
+	bpfDevcgDevBlock = 1
+	bpfDevcgDevChar  = 2
+
+	// instruction classes, operations and sources
+	bpfLdx   = 0x01
+	bpfJmp   = 0x05
+	bpfAlu   = 0x04
+	bpfAlu64 = 0x07
+	bpfW     = 0x00
+	bpfMem   = 0x60
+	bpfAnd   = 0x50
+	bpfRsh   = 0x70
+	bpfMov   = 0xb0
+	bpfJne   = 0x50
+	bpfExit  = 0x90
+	bpfK     = 0x00
+	bpfX     = 0x08
+)
+
+type bpfInsn struct {
+	Code uint8
+	Regs uint8 // dst in the lower nibble, src in the upper nibble
+	Off  int16
+	Imm  int32
+}
+
+func insn(code uint8, dst, src uint8, off int16, imm int32) bpfInsn {
+	return bpfInsn{Code: code, Regs: dst | src<<4, Off: off, Imm: imm}
+}
+
+type bpfProgLoadAttr struct {
+	progType    uint32
+	insnCnt     uint32
+	insns       uint64
+	license     uint64
+	logLevel    uint32
+	logSize     uint32
+	logBuf      uint64
+	kernVersion uint32
+	progFlags   uint32
+}
+
+type bpfProgAttachAttr struct {
+	targetFd    uint32
+	attachBpfFd uint32
+	attachType  uint32
+	attachFlags uint32
+}
+
+func accessBits(access string) int32 {
+	var bits int32
+	for _, c := range access {
+		switch c {
+		case 'm':
+			bits |= bpfDevcgAccMknod
+		case 'r':
+			bits |= bpfDevcgAccRead
+		case 'w':
+			bits |= bpfDevcgAccWrite
+		}
+	}
+	return bits
+}
+
+// deviceFilterProgram generates a program which returns the verdict of the last matching rule and denies by default,
+// later rules override earlier ones like writes to `devices.allow` and `devices.deny` do in cgroup v1
+func deviceFilterProgram(rules []*DeviceRule) []bpfInsn {
+	// r2 = device type, r3 = access type, r4 = major, r5 = minor
+	prog := []bpfInsn{
+		insn(bpfLdx|bpfMem|bpfW, 2, 1, 0, 0),
+		insn(bpfAlu|bpfAnd|bpfK, 2, 0, 0, 0xffff),
+		insn(bpfLdx|bpfMem|bpfW, 3, 1, 0, 0),
+		insn(bpfAlu|bpfRsh|bpfK, 3, 0, 0, 16),
+		insn(bpfLdx|bpfMem|bpfW, 4, 1, 4, 0),
+		insn(bpfLdx|bpfMem|bpfW, 5, 1, 8, 0),
+	}
+
+	for i := len(rules) - 1; i >= 0; i-- {
+		rule := rules[i]
+		// every condition jumps to the next rule when it does not match, offsets are fixed up below
+		var block []bpfInsn
+		switch rule.Type {
+		case CharDevice:
+			block = append(block, insn(bpfJmp|bpfJne|bpfK, 2, 0, 0, bpfDevcgDevChar))
+		case BlockDevice:
+			block = append(block, insn(bpfJmp|bpfJne|bpfK, 2, 0, 0, bpfDevcgDevBlock))
+		}
+		if access := accessBits(rule.Access); access != bpfDevcgAccMknod|bpfDevcgAccRead|bpfDevcgAccWrite {
+			// the requested access must be a subset of the allowed one
+			block = append(block,
+				insn(bpfAlu|bpfMov|bpfX, 1, 3, 0, 0),
+				insn(bpfAlu|bpfAnd|bpfK, 1, 0, 0, ^access&0x7),
+				insn(bpfJmp|bpfJne|bpfK, 1, 0, 0, 0),
+			)
+		}
+		if rule.Major != Wildcard {
+			block = append(block, insn(bpfJmp|bpfJne|bpfK, 4, 0, 0, int32(rule.Major)))
+		}
+		if rule.Minor != Wildcard {
+			block = append(block, insn(bpfJmp|bpfJne|bpfK, 5, 0, 0, int32(rule.Minor)))
+		}
+		var verdict int32
+		if rule.Allow {
+			verdict = 1
+		}
+		block = append(block,
+			insn(bpfAlu64|bpfMov|bpfK, 0, 0, 0, verdict),
+			insn(bpfJmp|bpfExit, 0, 0, 0, 0),
+		)
+		for i := range block {
+			if block[i].Code == bpfJmp|bpfJne|bpfK {
+				block[i].Off = int16(len(block) - i - 1)
+			}
+		}
+		prog = append(prog, block...)
+	}
+
+	return append(prog,
+		insn(bpfAlu64|bpfMov|bpfK, 0, 0, 0, 0),
+		insn(bpfJmp|bpfExit, 0, 0, 0, 0),
+	)
+}
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (uintptr, error) {
+	fd, _, errno := syscall.Syscall(sysBPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return 0, errno
+	}
+	return fd, nil
+}
+
+func loadDeviceFilter(prog []bpfInsn) (int, error) {
+	insns := new(bytes.Buffer)
+	if err := binary.Write(insns, binary.LittleEndian, prog); err != nil {
+		return -1, fmt.Errorf("cannot encode device filter: %v", err)
+	}
+	insnBytes := insns.Bytes()
+	license := []byte("Apache\x00")
+	logBuf := make([]byte, 64*1024)
+
+	attr := bpfProgLoadAttr{
+		progType: bpfProgTypeCgroupDevice,
+		insnCnt:  uint32(len(prog)),
+		insns:    uint64(uintptr(unsafe.Pointer(&insnBytes[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel: 1,
+		logSize:  uint32(len(logBuf)),
+		logBuf:   uint64(uintptr(unsafe.Pointer(&logBuf[0]))),
+	}
+	fd, err := bpf(bpfProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(insnBytes)
+	runtime.KeepAlive(license)
+	runtime.KeepAlive(logBuf)
+	if err != nil {
+		return -1, fmt.Errorf("cannot load device filter: %v, verifier log: %s", err, string(bytes.TrimRight(logBuf, "\x00")))
+	}
+	return int(fd), nil
+}
+
+// attachDeviceFilter replaces the device filter of the cgroup at `cgroupPath`
+func attachDeviceFilter(cgroupPath string, rules []*DeviceRule) error {
+	progFd, err := loadDeviceFilter(deviceFilterProgram(rules))
+	if err != nil {
+		return err
+	}
+	// the attachment holds its own reference to the program
+	defer func() {
+		if err := syscall.Close(progFd); err != nil {
+			panic(err)
+		}
+	}()
+
+	cgroupDir, err := os.Open(cgroupPath)
+	if err != nil {
+		return fmt.Errorf("cannot open cgroup dir `%s`: %v", cgroupPath, err)
+	}
+	defer func() {
+		if err := cgroupDir.Close(); err != nil {
+			panic(err)
+		}
+	}()
+
+	// without BPF_F_ALLOW_MULTI, attaching again replaces the previous program
+	attr := bpfProgAttachAttr{
+		targetFd:    uint32(cgroupDir.Fd()),
+		attachBpfFd: uint32(progFd),
+		attachType:  bpfCgroupDevice,
+	}
+	if _, err := bpf(bpfProgAttach, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); err != nil {
+		return fmt.Errorf("cannot attach device filter to `%s`: %v", cgroupPath, err)
+	}
+	return nil
+}
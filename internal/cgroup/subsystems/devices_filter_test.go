@@ -0,0 +1,92 @@
+package subsystems
+
+import "testing"
+
+// run interprets the instructions deviceFilterProgram emits against a `struct bpf_cgroup_dev_ctx`
+func run(t *testing.T, prog []bpfInsn, deviceType, access, major, minor uint32) int32 {
+	ctx := map[int16]uint32{0: access<<16 | deviceType, 4: major, 8: minor}
+	var regs [11]uint64
+	for pc := 0; pc < len(prog); pc++ {
+		in := prog[pc]
+		dst, src := in.Regs&0xf, in.Regs>>4
+		switch in.Code {
+		case bpfLdx | bpfMem | bpfW:
+			regs[dst] = uint64(ctx[in.Off])
+		case bpfAlu | bpfAnd | bpfK:
+			regs[dst] = uint64(uint32(regs[dst]) & uint32(in.Imm))
+		case bpfAlu | bpfRsh | bpfK:
+			regs[dst] = uint64(uint32(regs[dst]) >> uint32(in.Imm))
+		case bpfAlu | bpfMov | bpfX:
+			regs[dst] = uint64(uint32(regs[src]))
+		case bpfAlu64 | bpfMov | bpfK:
+			regs[dst] = uint64(int64(in.Imm))
+		case bpfJmp | bpfJne | bpfK:
+			if regs[dst] != uint64(int64(in.Imm)) {
+				pc += int(in.Off)
+			}
+		case bpfJmp | bpfExit:
+			return int32(regs[0])
+		default:
+			t.Fatalf("unexpected instruction %+v at %d", in, pc)
+		}
+	}
+	t.Fatal("program does not exit")
+	return 0
+}
+
+func rules(t *testing.T, inputs ...string) []*DeviceRule {
+	var parsed []*DeviceRule
+	for _, input := range inputs {
+		rule, err := ParseDeviceRule(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed = append(parsed, rule)
+	}
+	return parsed
+}
+
+func TestDeviceFilterProgramDeniesByDefault(t *testing.T) {
+	prog := deviceFilterProgram(nil)
+	if len(prog) != 8 {
+		t.Fatalf("program has %d instructions, want 8", len(prog))
+	}
+	if run(t, prog, bpfDevcgDevChar, bpfDevcgAccRead, 1, 3) != 0 {
+		t.Error("access allowed without rules")
+	}
+}
+
+func TestDeviceFilterProgram(t *testing.T) {
+	const (
+		r, w, m = bpfDevcgAccRead, bpfDevcgAccWrite, bpfDevcgAccMknod
+		c, b    = bpfDevcgDevChar, bpfDevcgDevBlock
+	)
+	denyNull := &DeviceRule{Type: CharDevice, Major: 1, Minor: 3, Access: "rwm"}
+	for _, test := range []struct {
+		name   string
+		rules  []*DeviceRule
+		device [4]uint32 // type, access, major, minor
+		want   int32
+	}{
+		{"all devices", rules(t, "a"), [4]uint32{b, r | w | m, 8, 0}, 1},
+		{"exact match", rules(t, "c 1:3 rwm"), [4]uint32{c, r | w, 1, 3}, 1},
+		{"other minor", rules(t, "c 1:3 rwm"), [4]uint32{c, r, 1, 5}, 0},
+		{"other major", rules(t, "c 1:3 rwm"), [4]uint32{c, r, 4, 3}, 0},
+		{"other type", rules(t, "c 1:3 rwm"), [4]uint32{b, r, 1, 3}, 0},
+		{"wildcards", rules(t, "c *:* rwm"), [4]uint32{c, w, 136, 7}, 1},
+		{"wildcard minor", rules(t, "b 8:* r"), [4]uint32{b, r, 8, 16}, 1},
+		{"subset of access", rules(t, "c 1:3 rw"), [4]uint32{c, w, 1, 3}, 1},
+		{"beyond access", rules(t, "c 1:3 rw"), [4]uint32{c, r | m, 1, 3}, 0},
+		{"later rule matches", rules(t, "c 1:3 r", "c 1:5 r"), [4]uint32{c, r, 1, 3}, 1},
+		{"later deny wins", append(rules(t, "a"), denyNull), [4]uint32{c, r, 1, 3}, 0},
+		{"later deny leaves others", append(rules(t, "a"), denyNull), [4]uint32{c, r, 1, 5}, 1},
+		{"later allow wins", append([]*DeviceRule{denyNull}, rules(t, "c 1:3 r")...), [4]uint32{c, r, 1, 3}, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			prog := deviceFilterProgram(test.rules)
+			if got := run(t, prog, test.device[0], test.device[1], test.device[2], test.device[3]); got != test.want {
+				t.Errorf("verdict %d, want %d", got, test.want)
+			}
+		})
+	}
+}
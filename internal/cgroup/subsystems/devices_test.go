@@ -0,0 +1,61 @@
+package subsystems
+
+import "testing"
+
+func TestParseDeviceRule(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		want  DeviceRule
+	}{
+		{"c 1:3 rwm", DeviceRule{Type: CharDevice, Major: 1, Minor: 3, Access: "rwm", Allow: true}},
+		{"c *:* rwm", DeviceRule{Type: CharDevice, Major: Wildcard, Minor: Wildcard, Access: "rwm", Allow: true}},
+		{"b 8:* r", DeviceRule{Type: BlockDevice, Major: 8, Minor: Wildcard, Access: "r", Allow: true}},
+		{"a *:* m", DeviceRule{Type: AllDevices, Major: Wildcard, Minor: Wildcard, Access: "m", Allow: true}},
+		{"a", DeviceRule{Type: AllDevices, Major: Wildcard, Minor: Wildcard, Access: "rwm", Allow: true}},
+		{"  c  136:0   rw ", DeviceRule{Type: CharDevice, Major: 136, Minor: 0, Access: "rw", Allow: true}},
+	} {
+		t.Run(test.input, func(t *testing.T) {
+			rule, err := ParseDeviceRule(test.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *rule != test.want {
+				t.Errorf("got %+v, want %+v", *rule, test.want)
+			}
+		})
+	}
+}
+
+func TestParseDeviceRuleRejectsInvalid(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"c",
+		"c 1:3",
+		"c 1:3 rwm extra",
+		"x 1:3 rwm",
+		"c 1 rwm",
+		"c 1:2:3 rwm",
+		"c -1:3 rwm",
+		"c 1:x rwm",
+		"c 1:3 rwx",
+		"c 1:3 -",
+	} {
+		t.Run(input, func(t *testing.T) {
+			if rule, err := ParseDeviceRule(input); err == nil {
+				t.Errorf("parsed as %+v", *rule)
+			}
+		})
+	}
+}
+
+func TestDeviceRuleStringRoundTrips(t *testing.T) {
+	for _, input := range []string{"c 1:3 rwm", "c *:* rwm", "b 8:* r"} {
+		rule, err := ParseDeviceRule(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rule.String() != input {
+			t.Errorf("%q is printed as %q", input, rule.String())
+		}
+	}
+}
@@ -8,14 +8,42 @@ import (
 	"strconv"
 )
 
+// MinMemoryLimit is the smallest memory limit a box can start with
+const MinMemoryLimit = 6 << 20
+
 type MemorySubSystem struct {
 }
 
 func (s *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
-		if res.MemoryLimit != "" {
-			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.limit_in_bytes"), []byte(res.MemoryLimit), 0644); err != nil {
-				return fmt.Errorf("set cgroup memory fail %v", err)
+		if IsCgroup2UnifiedMode() {
+			return s.setUnified(subsysCgroupPath, res)
+		}
+
+		if res.MemoryLimit != 0 {
+			limit := []byte(strconv.FormatInt(res.MemoryLimit, 10))
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.limit_in_bytes"), limit, 0644); err != nil {
+				// memory.limit_in_bytes cannot exceed memory.memsw.limit_in_bytes, raise the latter first
+				if res.MemorySwap == 0 {
+					return fmt.Errorf("set cgroup memory fail %v", err)
+				}
+				if err := s.setSwap(subsysCgroupPath, res); err != nil {
+					return err
+				}
+				if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.limit_in_bytes"), limit, 0644); err != nil {
+					return fmt.Errorf("set cgroup memory fail %v", err)
+				}
+			}
+		}
+		if res.MemorySwap != 0 {
+			if err := s.setSwap(subsysCgroupPath, res); err != nil {
+				return err
+			}
+		}
+		if res.MemoryReservation != 0 {
+			reservation := []byte(strconv.FormatInt(res.MemoryReservation, 10))
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.soft_limit_in_bytes"), reservation, 0644); err != nil {
+				return fmt.Errorf("set cgroup memory.soft_limit_in_bytes fail %v", err)
 			}
 		}
 		return nil
@@ -25,6 +53,39 @@ func (s *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 
 }
 
+func (s *MemorySubSystem) setSwap(subsysCgroupPath string, res *ResourceConfig) error {
+	swap := []byte(strconv.FormatInt(res.MemorySwap, 10))
+	if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.memsw.limit_in_bytes"), swap, 0644); err != nil {
+		return fmt.Errorf("set cgroup memory.memsw.limit_in_bytes fail %v", err)
+	}
+	return nil
+}
+
+// setUnified writes the settings to `memory.max`, `memory.swap.max` and `memory.low` of cgroup v2
+func (s *MemorySubSystem) setUnified(subsysCgroupPath string, res *ResourceConfig) error {
+	if res.MemoryLimit != 0 {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.max"), []byte(strconv.FormatInt(res.MemoryLimit, 10)), 0644); err != nil {
+			return fmt.Errorf("set cgroup memory.max fail %v", err)
+		}
+	}
+	if res.MemorySwap != 0 {
+		// memory.swap.max only counts swap while MemorySwap counts memory plus swap
+		swap := "max"
+		if res.MemorySwap > 0 {
+			swap = strconv.FormatInt(res.MemorySwap-res.MemoryLimit, 10)
+		}
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.swap.max"), []byte(swap), 0644); err != nil {
+			return fmt.Errorf("set cgroup memory.swap.max fail %v", err)
+		}
+	}
+	if res.MemoryReservation != 0 {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.low"), []byte(strconv.FormatInt(res.MemoryReservation, 10)), 0644); err != nil {
+			return fmt.Errorf("set cgroup memory.low fail %v", err)
+		}
+	}
+	return nil
+}
+
 func (s *MemorySubSystem) Remove(cgroupPath string) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
 		return os.RemoveAll(subsysCgroupPath)
@@ -35,7 +96,7 @@ func (s *MemorySubSystem) Remove(cgroupPath string) error {
 
 func (s *MemorySubSystem) Apply(cgroupPath string, pid int) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, procsFileName()), []byte(strconv.Itoa(pid)), 0644); err != nil {
 			return fmt.Errorf("set cgroup proc fail %v", err)
 		}
 		return nil
@@ -47,3 +108,21 @@ func (s *MemorySubSystem) Apply(cgroupPath string, pid int) error {
 func (s *MemorySubSystem) Name() string {
 	return "memory"
 }
+
+// OomKillCount returns how many processes of the cgroup were killed by the OOM killer
+func (s *MemorySubSystem) OomKillCount(cgroupPath string) (uint64, error) {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return 0, fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+
+	file := "memory.oom_control"
+	if IsCgroup2UnifiedMode() {
+		file = "memory.events"
+	}
+	values, err := readKeyValueFile(path.Join(subsysCgroupPath, file))
+	if err != nil {
+		return 0, err
+	}
+	return values["oom_kill"], nil
+}
@@ -0,0 +1,119 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// DefaultPidsLimit stops fork bombs in boxes started without `--pids-limit`
+const DefaultPidsLimit = 1024
+
+// PidsStats reports the number of processes in a cgroup, a `Limit` of -1 means unlimited
+type PidsStats struct {
+	Current uint64 `json:"current"`
+	Limit   int64  `json:"limit"`
+}
+
+type PidsSubSystem struct {
+}
+
+func (s *PidsSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
+		if res.PidsLimit != 0 {
+			limit := "max"
+			if res.PidsLimit > 0 {
+				limit = strconv.FormatInt(res.PidsLimit, 10)
+			}
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "pids.max"), []byte(limit), 0644); err != nil {
+				return fmt.Errorf("set cgroup pids.max fail %v", err)
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (s *PidsSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+func (s *PidsSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, procsFileName()), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *PidsSubSystem) Name() string {
+	return "pids"
+}
+
+func (s *PidsSubSystem) Stats(cgroupPath string) (*PidsStats, error) {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return nil, fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+
+	stats := &PidsStats{Limit: -1}
+	current, err := readCgroupFile(subsysCgroupPath, "pids.current")
+	if err != nil {
+		return nil, err
+	}
+	if stats.Current, err = strconv.ParseUint(current, 10, 64); err != nil {
+		return nil, fmt.Errorf("cannot parse pids.current `%s`: %v", current, err)
+	}
+
+	limit, err := readCgroupFile(subsysCgroupPath, "pids.max")
+	if err != nil {
+		return nil, err
+	}
+	if limit != "max" {
+		if stats.Limit, err = strconv.ParseInt(limit, 10, 64); err != nil {
+			return nil, fmt.Errorf("cannot parse pids.max `%s`: %v", limit, err)
+		}
+	}
+	return stats, nil
+}
+
+func readCgroupFile(subsysCgroupPath, file string) (string, error) {
+	content, err := ioutil.ReadFile(path.Join(subsysCgroupPath, file))
+	if err != nil {
+		return "", fmt.Errorf("cannot read cgroup file %s: %v", file, err)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// Procs lists the processes in a cgroup by their pids in the host
+func (s *PidsSubSystem) Procs(cgroupPath string) ([]int, error) {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return nil, fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+
+	content, err := readCgroupFile(subsysCgroupPath, "cgroup.procs")
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, line := range strings.Fields(content) {
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse pid `%s` in cgroup.procs: %v", line, err)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
@@ -0,0 +1,180 @@
+package subsystems
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Stats is a snapshot of the resource usage of a cgroup
+type Stats struct {
+	CpuUsageNs  uint64            `json:"cpuUsageNs"`
+	MemoryUsage uint64            `json:"memoryUsage"`
+	MemoryLimit uint64            `json:"memoryLimit"` // 0 for unlimited
+	MemoryStat  map[string]uint64 `json:"memoryStat"`
+	Pids        *PidsStats        `json:"pids"`
+	BlkioRead   uint64            `json:"blkioRead"`  // bytes
+	BlkioWrite  uint64            `json:"blkioWrite"` // bytes
+}
+
+// StatsReader is implemented by subsystems which account resource usage
+type StatsReader interface {
+	GetStats(path string, stats *Stats) error
+}
+
+func (s *CpuSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	if IsCgroup2UnifiedMode() {
+		subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+		if err != nil {
+			return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+		}
+		cpuStat, err := readKeyValueFile(path.Join(subsysCgroupPath, "cpu.stat"))
+		if err != nil {
+			return err
+		}
+		stats.CpuUsageNs = cpuStat["usage_usec"] * 1000
+		return nil
+	}
+
+	// cpu time is accounted by cpuacct, which is usually mounted together with cpu
+	subsysCgroupPath, err := GetCgroupPath("cpuacct", cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+	usage, err := readCgroupFile(subsysCgroupPath, "cpuacct.usage")
+	if err != nil {
+		return err
+	}
+	if stats.CpuUsageNs, err = strconv.ParseUint(usage, 10, 64); err != nil {
+		return fmt.Errorf("cannot parse cpuacct.usage `%s`: %v", usage, err)
+	}
+	return nil
+}
+
+func (s *MemorySubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+
+	usageFile, limitFile := "memory.usage_in_bytes", "memory.limit_in_bytes"
+	if IsCgroup2UnifiedMode() {
+		usageFile, limitFile = "memory.current", "memory.max"
+	}
+
+	usage, err := readCgroupFile(subsysCgroupPath, usageFile)
+	if err != nil {
+		return err
+	}
+	if stats.MemoryUsage, err = strconv.ParseUint(usage, 10, 64); err != nil {
+		return fmt.Errorf("cannot parse %s `%s`: %v", usageFile, usage, err)
+	}
+
+	limit, err := readCgroupFile(subsysCgroupPath, limitFile)
+	if err != nil {
+		return err
+	}
+	if limit != "max" {
+		if stats.MemoryLimit, err = strconv.ParseUint(limit, 10, 64); err != nil {
+			return fmt.Errorf("cannot parse %s `%s`: %v", limitFile, limit, err)
+		}
+		// cgroup v1 reports an unlimited cgroup with a huge page aligned number
+		if stats.MemoryLimit >= 1<<62 {
+			stats.MemoryLimit = 0
+		}
+	}
+
+	if stats.MemoryStat, err = readKeyValueFile(path.Join(subsysCgroupPath, "memory.stat")); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *PidsSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	pidsStats, err := s.Stats(cgroupPath)
+	if err != nil {
+		return err
+	}
+	stats.Pids = pidsStats
+	return nil
+}
+
+func (s *BlkioSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+
+	if IsCgroup2UnifiedMode() {
+		// lines like `8:0 rbytes=1 wbytes=2 rios=3 wios=4 dbytes=0 dios=0`
+		return scanLines(path.Join(subsysCgroupPath, "io.stat"), func(fields []string) {
+			for _, field := range fields[1:] {
+				kv := strings.SplitN(field, "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				value, _ := strconv.ParseUint(kv[1], 10, 64)
+				switch kv[0] {
+				case "rbytes":
+					stats.BlkioRead += value
+				case "wbytes":
+					stats.BlkioWrite += value
+				}
+			}
+		})
+	}
+
+	// lines like `8:0 Read 4096`
+	return scanLines(path.Join(subsysCgroupPath, "blkio.throttle.io_service_bytes"), func(fields []string) {
+		if len(fields) != 3 {
+			return
+		}
+		value, _ := strconv.ParseUint(fields[2], 10, 64)
+		switch fields[1] {
+		case "Read":
+			stats.BlkioRead += value
+		case "Write":
+			stats.BlkioWrite += value
+		}
+	})
+}
+
+// readKeyValueFile reads files like `memory.stat` which have a `key value` pair on each line
+func readKeyValueFile(file string) (map[string]uint64, error) {
+	values := map[string]uint64{}
+	err := scanLines(file, func(fields []string) {
+		if len(fields) != 2 {
+			return
+		}
+		if value, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = value
+		}
+	})
+	return values, err
+}
+
+func scanLines(file string, handle func(fields []string)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("cannot open %s: %v", file, err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			panic(err)
+		}
+	}()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+			handle(fields)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("cannot read %s: %v", file, err)
+	}
+	return nil
+}
@@ -1,11 +1,25 @@
 package subsystems
 
+import "fmt"
+
+// ResourceConfig holds the limits of a box, zero values leave the corresponding setting untouched
 type ResourceConfig struct {
-	MemoryLimit string
-	CpuShare    string
-	CpuSetCpus  string
-	CpuSetMems  string
-	CpuQuotaUs  string
+	MemoryLimit       int64         `json:"memoryLimit,omitempty"`       // bytes
+	MemorySwap        int64         `json:"memorySwap,omitempty"`        // memory plus swap in bytes, -1 for unlimited
+	MemoryReservation int64         `json:"memoryReservation,omitempty"` // bytes
+	CpuShare          uint64        `json:"cpuShare,omitempty"`
+	CpuPeriodUs       uint64        `json:"cpuPeriodUs,omitempty"`
+	CpuQuotaUs        int64         `json:"cpuQuotaUs,omitempty"` // -1 for unlimited
+	CpuSetCpus        string        `json:"cpuSetCpus,omitempty"`
+	CpuSetMems        string        `json:"cpuSetMems,omitempty"`
+	Devices           []*DeviceRule `json:"devices,omitempty"`
+	PidsLimit         int64         `json:"pidsLimit,omitempty"` // -1 for unlimited
+
+	BlkioWeight          uint16            `json:"blkioWeight,omitempty"`
+	BlkioDeviceReadBps   []*ThrottleDevice `json:"blkioDeviceReadBps,omitempty"`
+	BlkioDeviceWriteBps  []*ThrottleDevice `json:"blkioDeviceWriteBps,omitempty"`
+	BlkioDeviceReadIOps  []*ThrottleDevice `json:"blkioDeviceReadIOps,omitempty"`
+	BlkioDeviceWriteIOps []*ThrottleDevice `json:"blkioDeviceWriteIOps,omitempty"`
 }
 
 type Subsystem interface {
@@ -20,5 +34,109 @@ var (
 		&CpusetSubSystem{},
 		&MemorySubSystem{},
 		&CpuSubSystem{},
+		&DevicesSubSystem{},
+		&PidsSubSystem{},
+		&BlkioSubSystem{},
 	}
 )
+
+// Validate checks the values against each other and the host, so that no cgroup is touched with a bad config
+func (res *ResourceConfig) Validate() error {
+	if res.MemoryLimit < 0 {
+		return fmt.Errorf("invalid memory limit %d", res.MemoryLimit)
+	}
+	if res.MemoryLimit > 0 && res.MemoryLimit < MinMemoryLimit {
+		return fmt.Errorf("memory limit %d is below the minimum of %d bytes", res.MemoryLimit, MinMemoryLimit)
+	}
+	if res.MemorySwap != 0 && res.MemorySwap != -1 {
+		if res.MemoryLimit == 0 {
+			return fmt.Errorf("memory swap limit requires a memory limit")
+		}
+		if res.MemorySwap < res.MemoryLimit {
+			return fmt.Errorf("memory swap limit %d is smaller than memory limit %d", res.MemorySwap, res.MemoryLimit)
+		}
+	}
+	if res.MemoryReservation < 0 {
+		return fmt.Errorf("invalid memory reservation %d", res.MemoryReservation)
+	}
+	if res.MemoryLimit > 0 && res.MemoryReservation > res.MemoryLimit {
+		return fmt.Errorf("memory reservation %d is larger than memory limit %d", res.MemoryReservation, res.MemoryLimit)
+	}
+
+	if res.CpuShare != 0 && (res.CpuShare < MinCpuShare || res.CpuShare > MaxCpuShare) {
+		return fmt.Errorf("cpu share %d out of range [%d, %d]", res.CpuShare, MinCpuShare, MaxCpuShare)
+	}
+	if res.CpuPeriodUs != 0 && (res.CpuPeriodUs < MinCpuPeriodUs || res.CpuPeriodUs > MaxCpuPeriodUs) {
+		return fmt.Errorf("cpu period %dus out of range [%d, %d]", res.CpuPeriodUs, MinCpuPeriodUs, MaxCpuPeriodUs)
+	}
+	if res.CpuQuotaUs != 0 && res.CpuQuotaUs != -1 && res.CpuQuotaUs < MinCpuQuotaUs {
+		return fmt.Errorf("cpu quota %dus is below the minimum of %dus", res.CpuQuotaUs, MinCpuQuotaUs)
+	}
+	if res.CpuSetCpus != "" {
+		if err := validateOnline(res.CpuSetCpus, onlineCpusFile, "cpu"); err != nil {
+			return fmt.Errorf("invalid cpuset cpus: %v", err)
+		}
+	}
+	if res.CpuSetMems != "" {
+		if err := validateOnline(res.CpuSetMems, onlineNodesFile, "memory node"); err != nil {
+			return fmt.Errorf("invalid cpuset mems: %v", err)
+		}
+	}
+
+	if res.PidsLimit < -1 {
+		return fmt.Errorf("invalid pids limit %d", res.PidsLimit)
+	}
+	if res.BlkioWeight != 0 && (res.BlkioWeight < MinBlkioWeight || res.BlkioWeight > MaxBlkioWeight) {
+		return fmt.Errorf("blkio weight %d out of range [%d, %d]", res.BlkioWeight, MinBlkioWeight, MaxBlkioWeight)
+	}
+	return nil
+}
+
+// Merge overrides the values of `res` with every value set in `update`
+func (res *ResourceConfig) Merge(update *ResourceConfig) {
+	if update.MemoryLimit != 0 {
+		res.MemoryLimit = update.MemoryLimit
+	}
+	if update.MemorySwap != 0 {
+		res.MemorySwap = update.MemorySwap
+	}
+	if update.MemoryReservation != 0 {
+		res.MemoryReservation = update.MemoryReservation
+	}
+	if update.CpuShare != 0 {
+		res.CpuShare = update.CpuShare
+	}
+	if update.CpuPeriodUs != 0 {
+		res.CpuPeriodUs = update.CpuPeriodUs
+	}
+	if update.CpuQuotaUs != 0 {
+		res.CpuQuotaUs = update.CpuQuotaUs
+	}
+	if update.CpuSetCpus != "" {
+		res.CpuSetCpus = update.CpuSetCpus
+	}
+	if update.CpuSetMems != "" {
+		res.CpuSetMems = update.CpuSetMems
+	}
+	if update.Devices != nil {
+		res.Devices = update.Devices
+	}
+	if update.PidsLimit != 0 {
+		res.PidsLimit = update.PidsLimit
+	}
+	if update.BlkioWeight != 0 {
+		res.BlkioWeight = update.BlkioWeight
+	}
+	if update.BlkioDeviceReadBps != nil {
+		res.BlkioDeviceReadBps = update.BlkioDeviceReadBps
+	}
+	if update.BlkioDeviceWriteBps != nil {
+		res.BlkioDeviceWriteBps = update.BlkioDeviceWriteBps
+	}
+	if update.BlkioDeviceReadIOps != nil {
+		res.BlkioDeviceReadIOps = update.BlkioDeviceReadIOps
+	}
+	if update.BlkioDeviceWriteIOps != nil {
+		res.BlkioDeviceWriteIOps = update.BlkioDeviceWriteIOps
+	}
+}
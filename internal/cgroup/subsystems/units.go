@@ -0,0 +1,53 @@
+package subsystems
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var byteUnits = map[string]int64{
+	"":  1,
+	"b": 1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+}
+
+// ParseBytes parses a human-readable size like `512m`, `1.5GiB` or `1024`, all units are binary
+func ParseBytes(s string) (int64, error) {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	numberEnd := strings.IndexFunc(lower, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if numberEnd == -1 {
+		numberEnd = len(lower)
+	}
+	number, err := strconv.ParseFloat(lower[:numberEnd], 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size `%s`", s)
+	}
+
+	unit := strings.TrimSuffix(strings.TrimSuffix(lower[numberEnd:], "ib"), "b")
+	multiplier, ok := byteUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid unit in size `%s`", s)
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+// HumanSize formats a byte count with binary units, e.g. `1.5MiB`
+func HumanSize(bytes uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	size := float64(bytes)
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d%s", bytes, units[0])
+	}
+	return fmt.Sprintf("%.2f%s", size, units[unit])
+}
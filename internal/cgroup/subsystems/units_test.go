@@ -0,0 +1,41 @@
+package subsystems
+
+import "testing"
+
+func TestParseBytes(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		want  int64
+	}{
+		{"1024", 1024},
+		{"0", 0},
+		{"100b", 100},
+		{"2k", 2 << 10},
+		{" 2K ", 2 << 10},
+		{"512m", 512 << 20},
+		{"512MB", 512 << 20},
+		{"1GiB", 1 << 30},
+		{"1.5g", 3 << 29},
+		{"1t", 1 << 40},
+	} {
+		t.Run(test.input, func(t *testing.T) {
+			got, err := ParseBytes(test.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseBytesRejectsInvalid(t *testing.T) {
+	for _, input := range []string{"", " ", "-1", "-1m", "abc", "m", "1x", "1kk", "1.2.3", "1 g"} {
+		t.Run(input, func(t *testing.T) {
+			if got, err := ParseBytes(input); err == nil {
+				t.Errorf("parsed as %d", got)
+			}
+		})
+	}
+}
@@ -3,12 +3,41 @@ package subsystems
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"strings"
+	"syscall"
 )
 
+const (
+	// mountpoint of the unified hierarchy on hosts running cgroup v2 only
+	unifiedMountpoint = "/sys/fs/cgroup"
+	cgroup2SuperMagic = 0x63677270
+)
+
+func IsCgroup2UnifiedMode() bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(unifiedMountpoint, &st); err != nil {
+		return false
+	}
+	return st.Type == cgroup2SuperMagic
+}
+
+// procsFileName returns the file used to move a process into a cgroup
+func procsFileName() string {
+	if IsCgroup2UnifiedMode() {
+		return "cgroup.procs"
+	}
+	return "tasks"
+}
+
 func FindCgroupMountpoint(subsystem string) string {
+	// all controllers share one hierarchy in cgroup v2
+	if IsCgroup2UnifiedMode() {
+		return unifiedMountpoint
+	}
+
 	f, err := os.Open("/proc/self/mountinfo")
 	if err != nil {
 		return ""
@@ -36,13 +65,21 @@ func FindCgroupMountpoint(subsystem string) string {
 	return ""
 }
 
+// RemoveUnifiedCgroup removes a cgroup of the unified hierarchy, which all controllers share in cgroup v2,
+// a cgroup which is already gone counts as removed
+func RemoveUnifiedCgroup(cgroupPath string) error {
+	if err := os.Remove(path.Join(unifiedMountpoint, cgroupPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string, error) {
 	cgroupRoot := FindCgroupMountpoint(subsystem)
 	if _, err := os.Stat(path.Join(cgroupRoot, cgroupPath)); err == nil || (autoCreate && os.IsNotExist(err)) {
 		if os.IsNotExist(err) {
-			if err := os.Mkdir(path.Join(cgroupRoot, cgroupPath), 0755); err == nil {
-			} else {
-				return "", fmt.Errorf("cannot create cgroup %v", err)
+			if err := createCgroup(subsystem, cgroupRoot, cgroupPath); err != nil {
+				return "", err
 			}
 		}
 		return path.Join(cgroupRoot, cgroupPath), nil
@@ -50,3 +87,54 @@ func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string
 		return "", fmt.Errorf("cgroup path error: %v", err)
 	}
 }
+
+// createCgroup creates `cgroupPath` level by level, so that it may be nested like OCI bundles ask for
+func createCgroup(subsystem, cgroupRoot, cgroupPath string) error {
+	levels := strings.Split(strings.Trim(path.Clean(cgroupPath), "/"), "/")
+	current := cgroupRoot
+	for i, level := range levels {
+		current = path.Join(current, level)
+		if err := os.Mkdir(current, 0755); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("cannot create cgroup %v", err)
+		}
+		if i < len(levels)-1 {
+			if err := prepareIntermediateCgroup(subsystem, current); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// prepareIntermediateCgroup makes a cgroup able to host children, which the root cgroup always is
+func prepareIntermediateCgroup(subsystem, cgroupPath string) error {
+	if IsCgroup2UnifiedMode() {
+		// children only get the controllers enabled in their parent
+		controllers, err := readCgroupFile(cgroupPath, "cgroup.controllers")
+		if err != nil {
+			return err
+		}
+		for _, controller := range strings.Fields(controllers) {
+			if err := ioutil.WriteFile(path.Join(cgroupPath, "cgroup.subtree_control"), []byte("+"+controller), 0644); err != nil {
+				return fmt.Errorf("cannot enable controller %s in `%s`: %v", controller, cgroupPath, err)
+			}
+		}
+		return nil
+	}
+	if subsystem == "cpuset" {
+		// a v1 cpuset without cpus or mems cannot have tasks nor children with any
+		for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+			if !isCpusetEmpty(cgroupPath, file) {
+				continue
+			}
+			value, err := readCgroupFile(path.Dir(cgroupPath), file)
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(path.Join(cgroupPath, file), []byte(value), 0644); err != nil {
+				return fmt.Errorf("cannot set %s of `%s`: %v", file, cgroupPath, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/yqszxx/oreo-box/config"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	TypeBox     = "box"
+	TypeImage   = "image"
+	TypeNetwork = "network"
+)
+
+type Event struct {
+	Time       time.Time         `json:"time"`
+	Type       string            `json:"type"`
+	Action     string            `json:"action"`
+	Id         string            `json:"id"`
+	Name       string            `json:"name"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+func (e *Event) String() string {
+	var attributes []string
+	for key, value := range e.Attributes {
+		attributes = append(attributes, key+"="+value)
+	}
+	return fmt.Sprintf("%s %s %s %s (name=%s%s)",
+		e.Time.Format(time.RFC3339Nano), e.Type, e.Action, e.Id, e.Name, strings.Join(append([]string{""}, attributes...), ", "))
+}
+
+// Log appends an event to the journal, a failure is only logged since it must not abort the operation itself
+func Log(eventType, action, id, name string, attributes map[string]string) {
+	event := &Event{
+		Time:       time.Now(),
+		Type:       eventType,
+		Action:     action,
+		Id:         id,
+		Name:       name,
+		Attributes: attributes,
+	}
+	if err := write(event); err != nil {
+		log.Printf("cannot record %s event `%s` of `%s`: %v", eventType, action, name, err)
+	}
+}
+
+func write(event *Event) error {
+	eventBytes, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("cannot marshal event: %v", err)
+	}
+
+	if err := os.MkdirAll(path.Dir(config.EventsFilePath), 0755); err != nil {
+		return fmt.Errorf("cannot create dir of `%s`: %v", config.EventsFilePath, err)
+	}
+	journal, err := os.OpenFile(config.EventsFilePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open `%s`: %v", config.EventsFilePath, err)
+	}
+	defer func() {
+		if err := journal.Close(); err != nil {
+			panic(err)
+		}
+	}()
+
+	// several processes may append at the same time
+	if err := syscall.Flock(int(journal.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("cannot lock `%s`: %v", config.EventsFilePath, err)
+	}
+	if _, err := journal.Write(append(eventBytes, '\n')); err != nil {
+		return fmt.Errorf("cannot write `%s`: %v", config.EventsFilePath, err)
+	}
+	return nil
+}
+
+// Filter matches events whose fields equal one of the values given for each key,
+// keys are `type`, `action`, `id` and `name`
+type Filter map[string][]string
+
+func ParseFilter(filters []string) (Filter, error) {
+	filter := Filter{}
+	for _, f := range filters {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid filter `%s`, expected `key=value`", f)
+		}
+		switch kv[0] {
+		case "type", "action", "id", "name":
+		default:
+			return nil, fmt.Errorf("unknown filter key `%s`", kv[0])
+		}
+		filter[kv[0]] = append(filter[kv[0]], kv[1])
+	}
+	return filter, nil
+}
+
+func (f Filter) Match(event *Event) bool {
+	fields := map[string]string{
+		"type":   event.Type,
+		"action": event.Action,
+		"id":     event.Id,
+		"name":   event.Name,
+	}
+	for key, values := range f {
+		matched := false
+		for _, value := range values {
+			if fields[key] == value {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Follow calls `handle` for every event in the journal between `since` and `until`, a zero `until` keeps waiting
+// for new events forever
+func Follow(since, until time.Time, filter Filter, handle func(*Event) error) error {
+	journal, err := os.Open(config.EventsFilePath)
+	for os.IsNotExist(err) && until.IsZero() {
+		// nothing has happened yet
+		time.Sleep(time.Second)
+		journal, err = os.Open(config.EventsFilePath)
+	}
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot open `%s`: %v", config.EventsFilePath, err)
+	}
+	defer func() {
+		if err := journal.Close(); err != nil {
+			panic(err)
+		}
+	}()
+
+	reader := bufio.NewReader(journal)
+	var partial []byte
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if until.IsZero() || time.Now().Before(until) {
+				// keep the unfinished line until the writer completes it
+				partial = append(partial, line...)
+				time.Sleep(500 * time.Millisecond)
+				continue
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read `%s`: %v", config.EventsFilePath, err)
+		}
+		line = append(partial, line...)
+		partial = nil
+
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			log.Printf("skipping malformed event `%s`: %v", strings.TrimSpace(string(line)), err)
+			continue
+		}
+		if event.Time.Before(since) {
+			continue
+		}
+		if !until.IsZero() && event.Time.After(until) {
+			return nil
+		}
+		if filter.Match(&event) {
+			if err := handle(&event); err != nil {
+				return err
+			}
+		}
+	}
+}
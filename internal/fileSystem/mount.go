@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/yqszxx/oreo-box/config"
 	"github.com/yqszxx/oreo-box/internal"
+	"github.com/yqszxx/oreo-box/internal/image"
 	"log"
 	"os"
 	"path"
@@ -11,13 +12,14 @@ import (
 	"syscall"
 )
 
-//Create a AUFS filesystem as box root workspace
-func NewWorkSpace(volume, imageName, boxName string) error {
+//Create a AUFS filesystem as box root workspace, returning the image dirs it stacks
+func NewWorkSpace(volume, imageName, boxName string) ([]string, error) {
 	if err := CreateWriteLayer(boxName); err != nil {
-		return err
+		return nil, err
 	}
-	if err := MountImage(boxName, imageName); err != nil {
-		return err
+	layerDirs, err := MountImage(boxName, imageName)
+	if err != nil {
+		return nil, err
 	}
 
 	if volume != "" {
@@ -25,18 +27,23 @@ func NewWorkSpace(volume, imageName, boxName string) error {
 		length := len(volumePaths)
 		if length == 2 && volumePaths[0] != "" && volumePaths[1] != "" {
 			if err := MountVolume(volumePaths, boxName); err != nil {
-				return err
+				return nil, err
 			}
 			log.Printf("NewWorkSpace volume Paths %q \n", volumePaths)
 		} else {
 			log.Println("Volume parameter input is not correct.")
 		}
 	}
-	return nil
+	return layerDirs, nil
+}
+
+// WritableLayerDir is the upper branch of the workspace of a box, holding its changes to the image
+func WritableLayerDir(boxName string) string {
+	return path.Join(config.WritableLayerPath, boxName)
 }
 
 func CreateWriteLayer(boxName string) error {
-	writePath := path.Join(config.WritableLayerPath, boxName)
+	writePath := WritableLayerDir(boxName)
 	if err := os.MkdirAll(writePath, 0755); err != nil {
 		return fmt.Errorf("fail to make  write layer dir %s : %v", writePath, err)
 	}
@@ -61,22 +68,31 @@ func MountVolume(volumePaths []string, boxName string) error {
 	return nil
 }
 
-func MountImage(boxName, imageName string) error {
+func MountImage(boxName, imageName string) ([]string, error) {
 	mountPath := path.Join(config.BoxDataPath, boxName, config.MountPath)
 	if err := os.MkdirAll(mountPath, 0755); err != nil {
-		return fmt.Errorf("fail to make mountpoint dir %s : %v", mountPath, err)
+		return nil, fmt.Errorf("fail to make mountpoint dir %s : %v", mountPath, err)
+	}
+	writableLayerPath := WritableLayerDir(boxName)
+	layerDirs, err := image.LayerDirs(imageName)
+	if err != nil {
+		return nil, err
 	}
-	writableLayerPath := path.Join(config.WritableLayerPath, boxName)
-	imagePath := path.Join(config.ImagePath, imageName)
-	if !internal.Exist(imagePath, true) {
-		return fmt.Errorf("cannot find image `%s` at `%s`", imageName, imagePath)
+	for _, layerDir := range layerDirs {
+		if !internal.Exist(layerDir, true) {
+			return nil, fmt.Errorf("cannot find image `%s` at `%s`", imageName, layerDir)
+		}
 	}
 
-	dirs := "dirs=" + writableLayerPath + ":" + imagePath
+	// image layers are read-only branches whose whiteouts hide files of the layers below
+	dirs := "dirs=" + writableLayerPath + "=rw"
+	for _, layerDir := range layerDirs {
+		dirs += ":" + layerDir + "=ro+wh"
+	}
 	if err := syscall.Mount("none", mountPath, "aufs", 0, dirs); err != nil {
-		return fmt.Errorf("fail to mount aufs `%s` -> `%s`: %v", dirs, mountPath, err)
+		return nil, fmt.Errorf("fail to mount aufs `%s` -> `%s`: %v", dirs, mountPath, err)
 	}
-	return nil
+	return layerDirs, nil
 }
 
 //Delete the AUFS filesystem when box exits
@@ -120,7 +136,7 @@ func DeleteVolume(volumePaths []string, boxName string) error {
 }
 
 func DeleteWriteLayer(boxName string) error {
-	writePath := path.Join(config.WritableLayerPath, boxName)
+	writePath := WritableLayerDir(boxName)
 	if err := os.RemoveAll(writePath); err != nil {
 		return fmt.Errorf("fail to remove writeLayer dir %s : %v", writePath, err)
 	}
@@ -0,0 +1,375 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/yqszxx/oreo-box/internal/archive"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// the parts of the OCI image layout (https://github.com/opencontainers/image-spec) and of `docker save` archives used on import
+
+const (
+	ociLayoutFileName      = "oci-layout"
+	ociIndexFileName       = "index.json"
+	dockerManifestFileName = "manifest.json"
+
+	mediaTypeOciIndex    = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerIndex = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Platform  *struct {
+		Architecture string `json:"architecture"`
+		Os           string `json:"os"`
+	} `json:"platform,omitempty"`
+}
+
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	Config ociDescriptor   `json:"config"`
+	Layers []ociDescriptor `json:"layers"`
+}
+
+type dockerManifest struct {
+	Config string   `json:"Config"`
+	Layers []string `json:"Layers"`
+}
+
+// imageConfig is the config blob of an image
+type imageConfig struct {
+	Created      string    `json:"created"`
+	Architecture string    `json:"architecture"`
+	Os           string    `json:"os"`
+	Config       Config    `json:"config"`
+	History      []History `json:"history"`
+	RootFs       struct {
+		Type    string   `json:"type"`
+		DiffIds []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// detectFormat tells a layered archive from the signed format by its top-level entries
+func detectFormat(archivePath string) (string, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return "", fmt.Errorf("cannot open image file `%s`: %v", archivePath, err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			panic(err)
+		}
+	}()
+	uncompressed, err := archive.Decompress(file)
+	if err != nil {
+		return "", fmt.Errorf("cannot decompress image file `%s`: %v", archivePath, err)
+	}
+	defer func() {
+		_ = uncompressed.Close()
+	}()
+
+	entries := map[string]bool{}
+	reader := tar.NewReader(uncompressed)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("cannot read image file `%s`: %v", archivePath, err)
+		}
+		entries[filepath.Clean(header.Name)] = true
+	}
+
+	switch {
+	case entries[dockerManifestFileName]:
+		return FormatDocker, nil
+	case entries[ociLayoutFileName] && entries[ociIndexFileName]:
+		return FormatOci, nil
+	default:
+		// the signed format, which is checked on import
+		return "", nil
+	}
+}
+
+// archiveFile resolves a path found in an archive against the dir it was extracted to, refusing to leave it
+func archiveFile(dir, name string) (string, error) {
+	resolved := filepath.Join(dir, name)
+	if rel, err := filepath.Rel(dir, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("path `%s` leaves the archive", name)
+	}
+	return resolved, nil
+}
+
+func blobPath(dir, digest string) (string, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[0] != "sha256" || len(parts[1]) != sha256.Size*2 {
+		return "", fmt.Errorf("unsupported digest `%s`", digest)
+	}
+	return archiveFile(dir, filepath.Join("blobs", parts[0], parts[1]))
+}
+
+func digestHex(digest string) string {
+	return strings.TrimPrefix(digest, "sha256:")
+}
+
+func readJSONFile(filePath string, v interface{}) error {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("cannot parse `%s`: %v", filepath.Base(filePath), err)
+	}
+	return nil
+}
+
+func fileDigest(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			panic(err)
+		}
+	}()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// resolveOciManifest picks the manifest for this platform from the index of an OCI layout
+func resolveOciManifest(dir string) (*ociManifest, error) {
+	index := &ociIndex{}
+	if err := readJSONFile(filepath.Join(dir, ociIndexFileName), index); err != nil {
+		return nil, err
+	}
+	for depth := 0; depth < 8; depth++ {
+		var chosen *ociDescriptor
+		for i := range index.Manifests {
+			descriptor := &index.Manifests[i]
+			if descriptor.Platform == nil ||
+				(descriptor.Platform.Os == "linux" && descriptor.Platform.Architecture == runtime.GOARCH) {
+				chosen = descriptor
+				break
+			}
+		}
+		if chosen == nil {
+			return nil, fmt.Errorf("no image for linux/%s in the archive", runtime.GOARCH)
+		}
+		blob, err := blobPath(dir, chosen.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifyDigest(blob, chosen.Digest); err != nil {
+			return nil, err
+		}
+		if chosen.MediaType == mediaTypeOciIndex || chosen.MediaType == mediaTypeDockerIndex {
+			index = &ociIndex{}
+			if err := readJSONFile(blob, index); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		manifest := &ociManifest{}
+		if err := readJSONFile(blob, manifest); err != nil {
+			return nil, err
+		}
+		return manifest, nil
+	}
+	return nil, fmt.Errorf("image indexes nested too deep")
+}
+
+func verifyDigest(filePath, digest string) error {
+	actual, err := fileDigest(filePath)
+	if err != nil {
+		return fmt.Errorf("cannot hash `%s`: %v", filepath.Base(filePath), err)
+	}
+	if actual != digest {
+		return fmt.Errorf("digest mismatch for `%s`: expected %s, got %s", filepath.Base(filePath), digest, actual)
+	}
+	return nil
+}
+
+// readArchive reads the config and the layer files, bottom first, of an extracted layered archive
+func readArchive(dir, format string) (configPath string, layerPaths []string, err error) {
+	if format == FormatDocker {
+		var manifests []dockerManifest
+		if err := readJSONFile(filepath.Join(dir, dockerManifestFileName), &manifests); err != nil {
+			return "", nil, err
+		}
+		if len(manifests) != 1 {
+			return "", nil, fmt.Errorf("archive holds %d images, import one at a time", len(manifests))
+		}
+		if configPath, err = archiveFile(dir, manifests[0].Config); err != nil {
+			return "", nil, err
+		}
+		for _, layer := range manifests[0].Layers {
+			layerPath, err := archiveFile(dir, layer)
+			if err != nil {
+				return "", nil, err
+			}
+			layerPaths = append(layerPaths, layerPath)
+		}
+		return configPath, layerPaths, nil
+	}
+
+	manifest, err := resolveOciManifest(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	if configPath, err = blobPath(dir, manifest.Config.Digest); err != nil {
+		return "", nil, err
+	}
+	if err := verifyDigest(configPath, manifest.Config.Digest); err != nil {
+		return "", nil, err
+	}
+	for _, layer := range manifest.Layers {
+		layerPath, err := blobPath(dir, layer.Digest)
+		if err != nil {
+			return "", nil, err
+		}
+		if err := verifyDigest(layerPath, layer.Digest); err != nil {
+			return "", nil, err
+		}
+		layerPaths = append(layerPaths, layerPath)
+	}
+	return configPath, layerPaths, nil
+}
+
+// extractLayer extracts a layer into `dest` keeping its whiteout files, which aufs honors in `ro+wh` branches,
+// and checks the digest of the uncompressed layer against `diffId`
+func extractLayer(layerPath, diffId, dest string) error {
+	layer, err := os.Open(layerPath)
+	if err != nil {
+		return fmt.Errorf("cannot open layer: %v", err)
+	}
+	defer func() {
+		if err := layer.Close(); err != nil {
+			panic(err)
+		}
+	}()
+	uncompressed, err := archive.Decompress(layer)
+	if err != nil {
+		return fmt.Errorf("cannot decompress layer: %v", err)
+	}
+	defer func() {
+		_ = uncompressed.Close()
+	}()
+
+	hash := sha256.New()
+	if err := archive.Extract(io.TeeReader(uncompressed, hash), dest); err != nil {
+		return fmt.Errorf("fail to untar layer to `%s`: %v", dest, err)
+	}
+	// the tar reader may stop before the padding at the end
+	if _, err := io.Copy(hash, uncompressed); err != nil {
+		return fmt.Errorf("cannot read layer: %v", err)
+	}
+	if actual := "sha256:" + hex.EncodeToString(hash.Sum(nil)); actual != diffId {
+		return fmt.Errorf("layer digest mismatch: expected %s, got %s", diffId, actual)
+	}
+	return nil
+}
+
+// importLayered imports an OCI image layout or a `docker save` archive, keeping each layer in its own dir
+func importLayered(imageName, archivePath, format, signer string, options *ImportOptions) error {
+	// the archive is unpacked next to the stores rather than in /tmp, it may be large
+	imageTempDir, removeStaging, err := newStagingDir()
+	if err != nil {
+		return err
+	}
+	defer removeStaging()
+
+	if err := extractFile(archivePath, imageTempDir); err != nil {
+		return err
+	}
+
+	configPath, layerPaths, err := readArchive(imageTempDir, format)
+	if err != nil {
+		return fmt.Errorf("invalid %s archive: %v", format, err)
+	}
+	imageConf := &imageConfig{}
+	if err := readJSONFile(configPath, imageConf); err != nil {
+		return fmt.Errorf("cannot read image config: %v", err)
+	}
+	if imageConf.Os != "" && imageConf.Os != "linux" {
+		return fmt.Errorf("image is built for `%s`, not linux", imageConf.Os)
+	}
+	if len(imageConf.RootFs.DiffIds) != len(layerPaths) {
+		return fmt.Errorf("image config lists %d layers but the manifest has %d", len(imageConf.RootFs.DiffIds), len(layerPaths))
+	}
+	digest, err := fileDigest(configPath)
+	if err != nil {
+		return fmt.Errorf("cannot hash image config: %v", err)
+	}
+
+	metadata := &Metadata{
+		Name:         imageName,
+		Format:       format,
+		Digest:       digest,
+		Signer:       signer,
+		Imported:     time.Now().UTC(),
+		Architecture: imageConf.Architecture,
+		Os:           imageConf.Os,
+		Config:       imageConf.Config,
+		History:      imageConf.History,
+	}
+	if imageConf.Created != "" {
+		if metadata.Created, err = time.Parse(time.RFC3339Nano, imageConf.Created); err != nil {
+			return fmt.Errorf("invalid image creation time `%s`: %v", imageConf.Created, err)
+		}
+	}
+
+	unlock, err := lockLayerStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := checkImportNames(imageName, options); err != nil {
+		return err
+	}
+	previous, err := LoadMetadata(imageName)
+	if err != nil {
+		return err
+	}
+	for i, layerPath := range layerPaths {
+		diffId := imageConf.RootFs.DiffIds[i]
+		if _, err := blobPath("", diffId); err != nil {
+			return fmt.Errorf("invalid layer: %v", err)
+		}
+		// layers shared with other images are only stored once
+		if err := storeLayer(layerPath, diffId); err != nil {
+			return fmt.Errorf("cannot import layer %d: %v", i+1, err)
+		}
+		metadata.Layers = append(metadata.Layers, diffId)
+	}
+
+	configBlob, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("cannot read image config: %v", err)
+	}
+	if err := writeFileAtomic(configBlobPath(imageName), configBlob); err != nil {
+		return fmt.Errorf("cannot store image config: %v", err)
+	}
+	if err := saveMetadata(metadata); err != nil {
+		return err
+	}
+	return finishImport(imageName, format, previous, options)
+}
@@ -0,0 +1,170 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/yqszxx/oreo-box/config"
+	"github.com/yqszxx/oreo-box/internal/archive"
+	"github.com/yqszxx/oreo-box/internal/trust"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// signatures are armored and small, anything bigger is not a signature
+const maxSignatureSize = 1 << 20
+
+// bundle reads the signed format, a tarball holding `image.tar` and its detached signature `signature.asc`
+type bundle struct {
+	// rootfsDir receives the content of `image.tar`
+	rootfsDir string
+	// imageDataFilePath and signatureFilePath receive copies of the two files,
+	// `image.tar` is only copied if it is to be kept or verified after the signature which follows it
+	imageDataFilePath string
+	signatureFilePath string
+	verify            bool
+	keepImageData     bool
+
+	signature []byte
+	digest    string
+	signer    string
+}
+
+// read streams the bundle at `bundleFilePath` once, `image.tar` is hashed and extracted as it goes by,
+// and verified on the fly if the signature comes first, as in bundles written by `Save`,
+// otherwise it is verified from its copy afterwards
+func (b *bundle) read(bundleFilePath string) error {
+	bundleFile, err := os.Open(bundleFilePath)
+	if err != nil {
+		return fmt.Errorf("cannot open image file `%s`: %v", bundleFilePath, err)
+	}
+	defer func() {
+		if err := bundleFile.Close(); err != nil {
+			panic(err)
+		}
+	}()
+	uncompressed, err := archive.Decompress(bundleFile)
+	if err != nil {
+		return fmt.Errorf("cannot decompress image file `%s`: %v", bundleFilePath, err)
+	}
+	defer func() {
+		_ = uncompressed.Close()
+	}()
+
+	var key *trust.Key
+	seenImageData := false
+	reader := tar.NewReader(uncompressed)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read image file `%s`: %v", bundleFilePath, err)
+		}
+		switch path.Clean(header.Name) {
+		case config.SignatureFileName:
+			if b.signature, err = ioutil.ReadAll(io.LimitReader(reader, maxSignatureSize+1)); err != nil {
+				return fmt.Errorf("cannot read signature: %v", err)
+			}
+			if len(b.signature) > maxSignatureSize {
+				return fmt.Errorf("signature is larger than %d bytes", maxSignatureSize)
+			}
+			if err := ioutil.WriteFile(b.signatureFilePath, b.signature, 0644); err != nil {
+				return fmt.Errorf("cannot keep signature file: %v", err)
+			}
+		case config.ImageDataFileName:
+			if seenImageData {
+				return fmt.Errorf("image file has more than one `%s`", config.ImageDataFileName)
+			}
+			seenImageData = true
+			if key, err = b.readImageData(reader); err != nil {
+				return err
+			}
+		}
+	}
+	if !seenImageData {
+		return fmt.Errorf("image file has no `%s`", config.ImageDataFileName)
+	}
+
+	if b.verify && key == nil {
+		if b.signature == nil {
+			return fmt.Errorf("image file has no `%s`", config.SignatureFileName)
+		}
+		if key, err = verifyDetachedSignature(b.imageDataFilePath, b.signatureFilePath); err != nil {
+			return err
+		}
+	}
+	if key != nil {
+		b.signer = key.Fingerprint
+	}
+	return nil
+}
+
+// readImageData copies `image.tar` to its hash, its extraction, its verification if the signature is known,
+// and to its file if that is needed
+func (b *bundle) readImageData(r io.Reader) (*trust.Key, error) {
+	hash := sha256.New()
+	writers := []io.Writer{hash}
+	if b.keepImageData || (b.verify && b.signature == nil) {
+		imageDataFile, err := os.Create(b.imageDataFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot keep image data file: %v", err)
+		}
+		defer func() {
+			if err := imageDataFile.Close(); err != nil {
+				panic(err)
+			}
+		}()
+		writers = append(writers, imageDataFile)
+	}
+
+	type verifyResult struct {
+		key *trust.Key
+		err error
+	}
+	var verified chan verifyResult
+	var signed *io.PipeWriter
+	if b.verify && b.signature != nil {
+		var pipeReader *io.PipeReader
+		pipeReader, signed = io.Pipe()
+		writers = append(writers, signed)
+		verified = make(chan verifyResult, 1)
+		go func() {
+			key, err := trust.Verify(pipeReader, bytes.NewReader(b.signature))
+			// a failed verification stops the extraction
+			if err != nil {
+				_ = pipeReader.CloseWithError(err)
+			} else {
+				_, _ = io.Copy(ioutil.Discard, pipeReader)
+			}
+			verified <- verifyResult{key: key, err: err}
+		}()
+	}
+
+	tee := io.TeeReader(r, io.MultiWriter(writers...))
+	extractErr := archive.Extract(tee, b.rootfsDir)
+	if extractErr == nil {
+		// the tar reader may stop before the padding at the end
+		_, extractErr = io.Copy(ioutil.Discard, tee)
+	}
+
+	var key *trust.Key
+	if verified != nil {
+		_ = signed.CloseWithError(extractErr)
+		result := <-verified
+		if result.err != nil {
+			return nil, result.err
+		}
+		key = result.key
+	}
+	if extractErr != nil {
+		return nil, fmt.Errorf("fail to untar `%s`: %v", config.ImageDataFileName, extractErr)
+	}
+	b.digest = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	return key, nil
+}
@@ -0,0 +1,235 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/yqszxx/oreo-box/config"
+	"github.com/yqszxx/oreo-box/internal/events"
+	"github.com/yqszxx/oreo-box/internal/trust"
+	"golang.org/x/crypto/openpgp"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"time"
+)
+
+// FormatCommit images are committed from the writable layer of a box
+const FormatCommit = "commit"
+
+// CommitOptions tune `Commit`
+type CommitOptions struct {
+	// Comment is recorded in the history of the new layer
+	Comment string `json:"comment"`
+	// SignKeyPath is an armored private key signing the image, whose public key must be trusted
+	SignKeyPath string `json:"signKeyPath"`
+}
+
+// Commit creates image `imageName` by stacking the tree at `upperDir` onto image `parent`
+func Commit(parent, imageName, upperDir, createdBy string, options *CommitOptions) (*Metadata, error) {
+	if err := validateName(imageName); err != nil {
+		return nil, err
+	}
+	if _, tag, err := lookupTag(imageName); err != nil {
+		return nil, err
+	} else if tag != "" {
+		return nil, fmt.Errorf("`%s` is a tag of another image", imageName)
+	}
+	if exists, err := Exists(imageName); err != nil {
+		return nil, err
+	} else if exists {
+		return nil, fmt.Errorf("image `%s` already exists", imageName)
+	}
+	if exists, err := Exists(parent); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, &NotFoundError{Name: parent}
+	}
+	parentMetadata, err := LoadMetadata(parent)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := readSignKey(options.SignKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := lockLayerStore()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	now := time.Now().UTC()
+	imageConf := &imageConfig{
+		Created:      now.Format(time.RFC3339Nano),
+		Architecture: runtime.GOARCH,
+		Os:           "linux",
+	}
+	imageConf.RootFs.Type = "layers"
+	if parentMetadata != nil && parentMetadata.Format != FormatSigned {
+		imageConf.Config = parentMetadata.Config
+		imageConf.History = parentMetadata.History
+		imageConf.RootFs.DiffIds = parentMetadata.Layers
+	} else {
+		// a single tree becomes the base layer
+		baseId, err := commitLayer(path.Join(config.ImagePath, parent))
+		if err != nil {
+			return nil, fmt.Errorf("cannot create base layer from image `%s`: %v", parent, err)
+		}
+		imageConf.RootFs.DiffIds = []string{baseId}
+		imageConf.History = []History{{Created: now, CreatedBy: "import " + parent}}
+	}
+	diffId, err := commitLayer(upperDir)
+	if err != nil {
+		return nil, err
+	}
+	imageConf.RootFs.DiffIds = append(append([]string{}, imageConf.RootFs.DiffIds...), diffId)
+	imageConf.History = append(append([]History{}, imageConf.History...), History{
+		Created:   now,
+		CreatedBy: createdBy,
+		Comment:   options.Comment,
+	})
+
+	configBlob, err := json.Marshal(imageConf)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(configBlob)
+	metadata := &Metadata{
+		Name:         imageName,
+		Format:       FormatCommit,
+		Digest:       "sha256:" + hex.EncodeToString(hash[:]),
+		Created:      now,
+		Imported:     now,
+		Architecture: imageConf.Architecture,
+		Os:           imageConf.Os,
+		Config:       imageConf.Config,
+		Layers:       imageConf.RootFs.DiffIds,
+		History:      imageConf.History,
+	}
+
+	var signature []byte
+	if signer != nil {
+		if signature, metadata.Signer, err = signImage(signer, configBlob, layerDirsOf(imageConf.RootFs.DiffIds)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(config.ImageMetadataPath, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create image metadata dir: %v", err)
+	}
+	if err := ioutil.WriteFile(configBlobPath(imageName), configBlob, 0644); err != nil {
+		return nil, fmt.Errorf("cannot store image config: %v", err)
+	}
+	if signature != nil {
+		if err := ioutil.WriteFile(signatureFilePath(imageName), signature, 0644); err != nil {
+			return nil, fmt.Errorf("cannot store image signature: %v", err)
+		}
+	}
+	if err := saveMetadata(metadata); err != nil {
+		return nil, err
+	}
+
+	events.Log(events.TypeImage, "commit", imageName, imageName, map[string]string{"parent": parent})
+	return metadata, nil
+}
+
+// commitLayer stores the tree at `dir` as a layer and returns its digest
+func commitLayer(dir string) (string, error) {
+	if err := os.MkdirAll(config.ImageTempPath, 0755); err != nil {
+		return "", fmt.Errorf("cannot create temp image dir `%s`: %v", config.ImageTempPath, err)
+	}
+	layerFile, err := ioutil.TempFile(config.ImageTempPath, "layer-")
+	if err != nil {
+		return "", fmt.Errorf("cannot create layer file: %v", err)
+	}
+	defer func() {
+		if err := os.Remove(layerFile.Name()); err != nil {
+			panic(err)
+		}
+	}()
+	defer func() {
+		if err := layerFile.Close(); err != nil {
+			panic(err)
+		}
+	}()
+
+	hash := sha256.New()
+	if err := writeLayer(dir, io.MultiWriter(layerFile, hash)); err != nil {
+		return "", err
+	}
+	diffId := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	if err := storeLayer(layerFile.Name(), diffId); err != nil {
+		return "", err
+	}
+	return diffId, nil
+}
+
+// readSignKey reads the first private key of an armored key ring, an empty path means no signing
+func readSignKey(keyPath string) (*openpgp.Entity, error) {
+	if keyPath == "" {
+		return nil, nil
+	}
+	keyFile, err := os.Open(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open signing key `%s`: %v", keyPath, err)
+	}
+	defer func() {
+		if err := keyFile.Close(); err != nil {
+			panic(err)
+		}
+	}()
+	keyring, err := openpgp.ReadArmoredKeyRing(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read signing key `%s`: %v", keyPath, err)
+	}
+	for _, entity := range keyring {
+		if entity.PrivateKey == nil {
+			continue
+		}
+		if entity.PrivateKey.Encrypted {
+			return nil, fmt.Errorf("signing key `%s` is encrypted, which is not supported", keyPath)
+		}
+		return entity, nil
+	}
+	return nil, fmt.Errorf("no private key in `%s`", keyPath)
+}
+
+// signImage signs the flattened tree of the layers at `layerDirs`, which `Save` writes as `image.tar`, so that the
+// image is saved without the key. The key is first checked the way imports are checked on a signature of the small
+// config blob, so that only images signed by a trusted key are created, it returns the fingerprint of the key
+func signImage(signer *openpgp.Entity, configBlob []byte, layerDirs []string) ([]byte, string, error) {
+	signature := &bytes.Buffer{}
+	if err := openpgp.ArmoredDetachSign(signature, signer, bytes.NewReader(configBlob), nil); err != nil {
+		return nil, "", fmt.Errorf("cannot sign image: %v", err)
+	}
+	key, err := trust.Verify(bytes.NewReader(configBlob), bytes.NewReader(signature.Bytes()))
+	if err != nil {
+		return nil, "", err
+	}
+
+	flattened, w := io.Pipe()
+	// signing does not report read errors, those of the flattening are collected aside
+	flattenErr := make(chan error, 1)
+	go func() {
+		err := WriteFlattened(layerDirs, w)
+		_ = w.CloseWithError(err)
+		flattenErr <- err
+	}()
+	signature.Reset()
+	err = openpgp.ArmoredDetachSign(signature, signer, flattened, nil)
+	// stops the flattening if signing failed
+	_ = flattened.CloseWithError(err)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot sign image: %v", err)
+	}
+	if err := <-flattenErr; err != nil {
+		return nil, "", err
+	}
+	return signature.Bytes(), key.Fingerprint, nil
+}
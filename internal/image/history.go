@@ -0,0 +1,61 @@
+package image
+
+import (
+	"fmt"
+	"time"
+)
+
+// HistoryEntry is a step of building an image along with the layer it made, if any
+type HistoryEntry struct {
+	Created   time.Time `json:"created"`
+	CreatedBy string    `json:"createdBy"`
+	Comment   string    `json:"comment,omitempty"`
+	// Layer is empty for steps which changed only the config
+	Layer string `json:"layer,omitempty"`
+	// Size is the disk usage of the layer in bytes
+	Size int64 `json:"size"`
+}
+
+// LayerHistory returns how the layered image `reference` refers to was built, latest step first
+func LayerHistory(reference string) ([]*HistoryEntry, error) {
+	imageName, err := Resolve(reference)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := LoadMetadata(imageName)
+	if err != nil {
+		return nil, err
+	}
+	if metadata == nil || metadata.Format == FormatSigned {
+		return nil, fmt.Errorf("image `%s` is not layered and has no history", imageName)
+	}
+
+	// steps which made no layer are marked, layers are listed in the order of the other steps
+	var entries []*HistoryEntry
+	layers := metadata.Layers
+	for _, history := range metadata.History {
+		entry := &HistoryEntry{Created: history.Created, CreatedBy: history.CreatedBy, Comment: history.Comment}
+		if !history.EmptyLayer && len(layers) > 0 {
+			entry.Layer = layers[0]
+			layers = layers[1:]
+		}
+		entries = append(entries, entry)
+	}
+	// images may come with less history than layers
+	for _, layer := range layers {
+		entries = append(entries, &HistoryEntry{Layer: layer})
+	}
+	for _, entry := range entries {
+		if entry.Layer == "" {
+			continue
+		}
+		if entry.Size, err = dirSize(layerDir(entry.Layer)); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
@@ -3,34 +3,247 @@ package image
 import (
 	"fmt"
 	"github.com/yqszxx/oreo-box/config"
-	"golang.org/x/crypto/openpgp"
+	"github.com/yqszxx/oreo-box/internal"
+	"github.com/yqszxx/oreo-box/internal/archive"
+	"github.com/yqszxx/oreo-box/internal/events"
+	"github.com/yqszxx/oreo-box/internal/trust"
+	"log"
 	"os"
-	"os/exec"
 	"path"
 	"strings"
+	"time"
 )
 
-func Import(imageName, imageFilePath string) error {
-	imageTempDir := path.Join(config.ImageTempPath, imageName)
-	if err := os.MkdirAll(imageTempDir, 0755); err != nil {
-		return fmt.Errorf("cannot create temp image dir `%s`: %v", imageTempDir, err)
+// ImportOptions tune `Import`
+type ImportOptions struct {
+	// InsecureSkipVerify imports images whose signature is missing or not made by a trusted key
+	InsecureSkipVerify bool `json:"insecureSkipVerify"`
+	// Force replaces an image of the same name unless boxes use it, and moves the tags from other images
+	Force bool `json:"force"`
+	// Tags are further names of the image
+	Tags []string `json:"tags,omitempty"`
+	// KeepArchive keeps the verified `image.tar` of a signed image along with its signature,
+	// so that `Save` writes it as imported without a signing key, at the cost of storing the image twice
+	KeepArchive bool `json:"keepArchive"`
+}
+
+// Import imports an image from the signed format (`image.tar` + `signature.asc`), an OCI image layout
+// or a `docker save` archive, the latter two must come with a detached signature at `<archive>.asc`
+func Import(imageName, imageFilePath string, options *ImportOptions) error {
+	if options == nil {
+		options = &ImportOptions{}
 	}
-	defer func() {
-		if err := os.RemoveAll(imageTempDir); err != nil {
-			panic(err)
+	// the names are checked again once the image is read, right before it is moved into place
+	if err := checkImportNames(imageName, options); err != nil {
+		return err
+	}
+	format, err := detectFormat(imageFilePath)
+	if err != nil {
+		return err
+	}
+	if format == "" {
+		return importSigned(imageName, imageFilePath, options)
+	}
+	signer, err := verifyImage(imageFilePath, imageFilePath+".asc", options)
+	if err != nil {
+		return err
+	}
+	if err := importLayered(imageName, imageFilePath, format, signer, options); err != nil {
+		return err
+	}
+	// import success
+	events.Log(events.TypeImage, "import", imageName, imageName, map[string]string{"format": format, "signer": signer})
+	return nil
+}
+
+// checkImportNames refuses to import over an existing image unless forced, or over one that boxes use at all,
+// and checks the tags. It runs before anything is imported, and again once the layer store is locked
+// since other imports may have taken the names meanwhile
+func checkImportNames(imageName string, options *ImportOptions) error {
+	if err := validateName(imageName); err != nil {
+		if strings.Contains(imageName, ":") {
+			return fmt.Errorf("%v, tag the image instead", err)
 		}
-	}()
+		return err
+	}
+	tags, err := loadTags()
+	if err != nil {
+		return err
+	}
+	if target, ok := tags[normalizeTag(imageName)]; ok {
+		return fmt.Errorf("`%s` is a tag of image `%s`", imageName, target)
+	}
+	for _, tag := range options.Tags {
+		if normalizeTag(tag) == normalizeTag(imageName) {
+			return fmt.Errorf("tag `%s` is the name of the image", tag)
+		}
+		if _, err := validateTag(tags, tag, imageName, options.Force); err != nil {
+			return err
+		}
+	}
 
-	if _, err := exec.Command("tar", "-xvf", imageFilePath, "-C", imageTempDir).CombinedOutput(); err != nil {
-		return fmt.Errorf("fail to untar `%s` to `%s`: %v", imageFilePath, imageTempDir, err)
+	exists, err := Exists(imageName)
+	if err != nil {
+		return err
 	}
+	if !exists {
+		return nil
+	}
+	if !options.Force {
+		return fmt.Errorf("image `%s` already exists, force the import to replace it", imageName)
+	}
+	boxes, err := Users(imageName)
+	if err != nil {
+		return fmt.Errorf("cannot find boxes using image `%s`: %v", imageName, err)
+	}
+	if len(boxes) > 0 {
+		return &InUseError{Name: imageName, Boxes: boxes}
+	}
+	return nil
+}
 
-	keyRingReader := strings.NewReader(config.PublicKey)
+// finishImport cleans up after the `previous` image of the same name and adds the tags of the import,
+// the layer store must be locked
+func finishImport(imageName, format string, previous *Metadata, options *ImportOptions) error {
+	if err := cleanReplaced(imageName, format, previous); err != nil {
+		return err
+	}
+	return addTags(imageName, options.Tags, options.Force)
+}
 
-	signatureFilePath := path.Join(config.ImageTempPath, imageName, config.SignatureFileName)
+// cleanReplaced removes what the `previous` image of the same name left behind once it is replaced
+// by an image of `format`, the layer store must be locked
+func cleanReplaced(imageName, format string, previous *Metadata) error {
+	var leftovers []string
+	if format == FormatSigned {
+		leftovers = []string{configBlobPath(imageName)}
+	} else {
+		// layered images have no tree of their own and keep no signature
+		imageDataDir := path.Join(config.ImagePath, imageName)
+		if err := os.RemoveAll(imageDataDir); err != nil {
+			return fmt.Errorf("cannot remove replaced image dir `%s`: %v", imageDataDir, err)
+		}
+		leftovers = []string{imageDataPath(imageName), signatureFilePath(imageName)}
+	}
+	for _, filePath := range leftovers {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot remove `%s`: %v", filePath, err)
+		}
+	}
+	if previous != nil {
+		return removeUnreferencedLayers(previous.Layers)
+	}
+	return nil
+}
+
+// verifyImage returns the fingerprint of the key which signed an image, which is empty if verification is skipped
+func verifyImage(imageDataFilePath, signatureFilePath string, options *ImportOptions) (string, error) {
+	if options.InsecureSkipVerify {
+		log.Printf("Skipping signature verification of `%s`", imageDataFilePath)
+		return "", nil
+	}
+	key, err := verifyDetachedSignature(imageDataFilePath, signatureFilePath)
+	if err != nil {
+		return "", err
+	}
+	return key.Fingerprint, nil
+}
+
+// importSigned streams the bundle once, `image.tar` is extracted while it is hashed and checked against the signature,
+// everything lands in a staging dir which is moved into place only once the signature is valid
+func importSigned(imageName, imageFilePath string, options *ImportOptions) error {
+	staging, removeStaging, err := newStagingDir()
+	if err != nil {
+		return err
+	}
+	defer removeStaging()
+
+	b := &bundle{
+		rootfsDir:         path.Join(staging, "rootfs"),
+		imageDataFilePath: path.Join(staging, config.ImageDataFileName),
+		signatureFilePath: path.Join(staging, config.SignatureFileName),
+		verify:            !options.InsecureSkipVerify,
+		keepImageData:     options.KeepArchive,
+	}
+	if err := b.read(imageFilePath); err != nil {
+		return err
+	}
+	if options.InsecureSkipVerify {
+		log.Printf("Skipping signature verification of `%s`", imageFilePath)
+	}
+
+	unlock, err := lockLayerStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := checkImportNames(imageName, options); err != nil {
+		return err
+	}
+	previous, err := LoadMetadata(imageName)
+	if err != nil {
+		return err
+	}
+
+	// signature valid, move the image into the image store
+	if err := os.MkdirAll(config.ImageMetadataPath, 0755); err != nil {
+		return fmt.Errorf("cannot create image metadata dir: %v", err)
+	}
+	imageDataDir := path.Join(config.ImagePath, imageName)
+	replacedDir := path.Join(staging, "replaced")
+	replacing := internal.Exist(imageDataDir, true)
+	if replacing {
+		// the tree being replaced is swapped out right before the new one is moved in, and removed with the staging dir
+		if err := os.Rename(imageDataDir, replacedDir); err != nil {
+			return fmt.Errorf("cannot move replaced image aside: %v", err)
+		}
+	}
+	if err := os.Rename(b.rootfsDir, imageDataDir); err != nil {
+		if replacing {
+			if err := os.Rename(replacedDir, imageDataDir); err != nil {
+				log.Printf("Cannot restore replaced image `%s`: %v", imageName, err)
+			}
+		}
+		return fmt.Errorf("cannot move image into place: %v", err)
+	}
+	// the verified bundle is kept on request so that the image can be saved without signing it again,
+	// otherwise it goes with the staging dir along with what a replaced image kept
+	leftovers := []string{imageDataPath(imageName), signatureFilePath(imageName)}
+	if options.KeepArchive {
+		if err := os.Rename(b.imageDataFilePath, imageDataPath(imageName)); err != nil {
+			return fmt.Errorf("cannot keep image data file: %v", err)
+		}
+		leftovers = leftovers[1:]
+		// an unverified image is kept without signature, it cannot be saved unless signed again
+		if b.signer != "" {
+			if err := os.Rename(b.signatureFilePath, signatureFilePath(imageName)); err != nil {
+				return fmt.Errorf("cannot keep signature file: %v", err)
+			}
+			leftovers = nil
+		}
+	}
+	for _, filePath := range leftovers {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot remove replaced `%s`: %v", filePath, err)
+		}
+	}
+	if err := saveMetadata(&Metadata{Name: imageName, Format: FormatSigned, Digest: b.digest, Signer: b.signer, Imported: time.Now().UTC()}); err != nil {
+		return err
+	}
+	if err := finishImport(imageName, FormatSigned, previous, options); err != nil {
+		return err
+	}
+
+	// import success
+	events.Log(events.TypeImage, "import", imageName, imageName, map[string]string{"format": FormatSigned, "signer": b.signer})
+
+	return nil
+}
+
+func verifyDetachedSignature(imageDataFilePath, signatureFilePath string) (*trust.Key, error) {
 	signatureFile, err := os.Open(signatureFilePath)
 	if err != nil {
-		return fmt.Errorf("cannot open signature file `%s`: %v", signatureFilePath, err)
+		return nil, fmt.Errorf("cannot open signature file `%s`: %v", signatureFilePath, err)
 	}
 	defer func() {
 		if err := signatureFile.Close(); err != nil {
@@ -38,10 +251,9 @@ func Import(imageName, imageFilePath string) error {
 		}
 	}()
 
-	imageDataFilePath := path.Join(config.ImageTempPath, imageName, config.ImageDataFileName)
 	imageDataFile, err := os.Open(imageDataFilePath)
 	if err != nil {
-		return fmt.Errorf("cannot open image data file `%s`: %v", imageDataFilePath, err)
+		return nil, fmt.Errorf("cannot open image data file `%s`: %v", imageDataFilePath, err)
 	}
 	defer func() {
 		if err := imageDataFile.Close(); err != nil {
@@ -49,27 +261,22 @@ func Import(imageName, imageFilePath string) error {
 		}
 	}()
 
-	keyring, err := openpgp.ReadArmoredKeyRing(keyRingReader)
-	if err != nil {
-		return fmt.Errorf("cannot read public key ring: %v", err)
-	}
-
-	if _, err := openpgp.CheckArmoredDetachedSignature(keyring, imageDataFile, signatureFile); err != nil {
-		return fmt.Errorf("cannot verify image signature: %v", err)
-	}
+	return trust.Verify(imageDataFile, signatureFile)
+}
 
-	// signature valid, untar image to image store
-	imageDataDir := path.Join(config.ImagePath, imageName)
-	if err := os.MkdirAll(imageDataDir, 0755); err != nil {
-		return fmt.Errorf("cannot create image storage dir `%s`: %v", imageDataDir, err)
+// extractFile extracts the tarball at `filePath`, which may be compressed, into `dest`
+func extractFile(filePath, dest string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("cannot open `%s`: %v", filePath, err)
 	}
-
-	if _, err := exec.Command("tar", "-xvf", imageDataFilePath, "-C", imageDataDir).CombinedOutput(); err != nil {
-		return fmt.Errorf("fail to untar `%s` to `%s`: %v", imageDataFilePath, imageDataDir, err)
+	defer func() {
+		if err := file.Close(); err != nil {
+			panic(err)
+		}
+	}()
+	if err := archive.Extract(file, dest); err != nil {
+		return fmt.Errorf("fail to untar `%s` to `%s`: %v", filePath, dest, err)
 	}
-
-	// import success
-	fmt.Println(imageName)
-
 	return nil
 }
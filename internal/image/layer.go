@@ -0,0 +1,234 @@
+package image
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	// whiteoutOpaque in a dir hides everything below it in the lower layers
+	whiteoutOpaque = whiteoutPrefix + whiteoutPrefix + ".opq"
+	// aufs keeps its bookkeeping in `.wh..wh.` files other than the opaque marker, e.g. `.wh..wh.plnk`
+	aufsMetaPrefix = whiteoutPrefix + whiteoutPrefix
+)
+
+// writeLayer writes the tree at `dir` as a layer tarball, translating the whiteouts of the
+// writable layer of a box, which are the OCI ones for aufs and 0:0 char devices and opaque xattrs for overlay
+func writeLayer(dir string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	// hardlinked files are written once, later links point at the first name
+	links := map[uint64]string{}
+
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		base := filepath.Base(rel)
+		if isAufsMeta(base) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if isOverlayWhiteout(info) {
+			return writeMarker(tw, filepath.Join(filepath.Dir(rel), whiteoutPrefix+base), info)
+		}
+		if err := writeEntry(tw, filePath, rel, info, links); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if opaque, err := isOverlayOpaque(filePath); err != nil {
+				return err
+			} else if opaque {
+				return writeMarker(tw, filepath.Join(rel, whiteoutOpaque), info)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot write layer of `%s`: %v", dir, err)
+	}
+	return tw.Close()
+}
+
+// WriteFlattened writes the layers at `dirs`, top first, as a single tree tarball with the whiteouts applied
+func WriteFlattened(dirs []string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	// written paths, true for dirs, whose lower namesakes are hidden
+	written := map[string]bool{}
+	// paths removed by whiteouts, and dirs whose lower contents are hidden
+	removed := map[string]bool{}
+	opaque := map[string]bool{}
+
+	hidden := func(rel string) bool {
+		if removed[rel] {
+			return true
+		}
+		for parent := filepath.Dir(rel); parent != "."; parent = filepath.Dir(parent) {
+			if removed[parent] || opaque[parent] {
+				return true
+			}
+			if isDir, ok := written[parent]; ok && !isDir {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, dir := range dirs {
+		links := map[uint64]string{}
+		// the whiteouts of a layer only apply to the layers below it
+		layerRemoved := map[string]bool{}
+		layerOpaque := map[string]bool{}
+
+		err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, filePath)
+			if err != nil {
+				return err
+			}
+			if rel == "." {
+				return nil
+			}
+			base := filepath.Base(rel)
+			switch {
+			case base == whiteoutOpaque:
+				layerOpaque[filepath.Dir(rel)] = true
+				return nil
+			case isAufsMeta(base):
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			case strings.HasPrefix(base, whiteoutPrefix):
+				layerRemoved[filepath.Join(filepath.Dir(rel), strings.TrimPrefix(base, whiteoutPrefix))] = true
+				return nil
+			case isOverlayWhiteout(info):
+				layerRemoved[rel] = true
+				return nil
+			}
+
+			if hidden(rel) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() {
+				if isOpaque, err := isOverlayOpaque(filePath); err != nil {
+					return err
+				} else if isOpaque {
+					layerOpaque[rel] = true
+				}
+			}
+			if _, ok := written[rel]; ok {
+				return nil
+			}
+			written[rel] = info.IsDir()
+			return writeEntry(tw, filePath, rel, info, links)
+		})
+		if err != nil {
+			return fmt.Errorf("cannot write layer of `%s`: %v", dir, err)
+		}
+		for rel := range layerRemoved {
+			removed[rel] = true
+		}
+		for rel := range layerOpaque {
+			opaque[rel] = true
+		}
+	}
+	return tw.Close()
+}
+
+func isAufsMeta(base string) bool {
+	return strings.HasPrefix(base, aufsMetaPrefix) && base != whiteoutOpaque
+}
+
+func isOverlayWhiteout(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && info.Mode()&os.ModeCharDevice != 0 && stat.Rdev == 0
+}
+
+// writeMarker writes an empty whiteout file
+func writeMarker(tw *tar.Writer, name string, info os.FileInfo) error {
+	return tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0600,
+		ModTime:  info.ModTime(),
+	})
+}
+
+// writeEntry writes a file of the tree, `links` maps the inodes of hardlinked files to their first name
+func writeEntry(tw *tar.Writer, filePath, rel string, info os.FileInfo, links map[uint64]string) error {
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(filePath); err != nil {
+			return err
+		}
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf("cannot describe `%s`: %v", rel, err)
+	}
+	header.Name = rel
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && info.Mode().IsRegular() && stat.Nlink > 1 {
+		if first, ok := links[stat.Ino]; ok {
+			header.Typeflag = tar.TypeLink
+			header.Linkname = first
+			header.Size = 0
+		} else {
+			links[stat.Ino] = rel
+		}
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			panic(err)
+		}
+	}()
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+func isOverlayOpaque(dir string) (bool, error) {
+	value := make([]byte, 1)
+	n, err := syscall.Getxattr(dir, "trusted.overlay.opaque", value)
+	if err == syscall.ENODATA || err == syscall.ENOTSUP {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot read xattrs of `%s`: %v", dir, err)
+	}
+	return n == 1 && value[0] == 'y', nil
+}
@@ -0,0 +1,287 @@
+package image
+
+import (
+	"fmt"
+	"github.com/yqszxx/oreo-box/config"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// layers are stored once by the digest of their uncompressed content and shared by the images listing them
+
+// stagingPrefix marks dirs holding imports in progress, in both the layer store and the image store
+const stagingPrefix = ".staging-"
+
+// removedPrefix marks dirs of removed images in the image store which boxes still stack, they go once no box does
+const removedPrefix = ".removed-"
+
+// PruneReport lists the layers removed by `Prune`
+type PruneReport struct {
+	Layers []string `json:"layers"`
+	// SpaceReclaimed is in bytes
+	SpaceReclaimed int64 `json:"spaceReclaimed"`
+}
+
+func layerDir(diffId string) string {
+	return path.Join(config.LayerPath, digestHex(diffId))
+}
+
+// lockLayerStore serializes imports and prunes, so that a layer being imported is never pruned
+func lockLayerStore() (func(), error) {
+	if err := os.MkdirAll(config.LayerPath, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create layer dir `%s`: %v", config.LayerPath, err)
+	}
+	lockFilePath := path.Join(config.LayerPath, config.LayerLockFileName)
+	lockFile, err := os.OpenFile(lockFilePath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open lock file `%s`: %v", lockFilePath, err)
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		_ = lockFile.Close()
+		return nil, fmt.Errorf("cannot lock layer store: %v", err)
+	}
+	return func() {
+		if err := lockFile.Close(); err != nil {
+			panic(err)
+		}
+	}, nil
+}
+
+// newStagingDir creates a dir in the image store for an import in progress,
+// being on the same filesystem its content can be moved into place atomically.
+// The dir is flock'd until `remove` removes it, so that `Prune` leaves it alone meanwhile
+func newStagingDir() (staging string, remove func(), err error) {
+	if err := os.MkdirAll(config.ImagePath, 0755); err != nil {
+		return "", nil, fmt.Errorf("cannot create image dir `%s`: %v", config.ImagePath, err)
+	}
+	staging, err = ioutil.TempDir(config.ImagePath, stagingPrefix)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot create staging dir: %v", err)
+	}
+	lock, err := os.Open(staging)
+	if err == nil {
+		err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX)
+	}
+	if err != nil {
+		if lock != nil {
+			_ = lock.Close()
+		}
+		_ = os.RemoveAll(staging)
+		return "", nil, fmt.Errorf("cannot lock staging dir: %v", err)
+	}
+	return staging, func() {
+		if err := os.RemoveAll(staging); err != nil {
+			log.Printf("Cannot clean up staging dir `%s`: %v", staging, err)
+		}
+		_ = lock.Close()
+	}, nil
+}
+
+// removeStagingDir removes a staging dir left by an interrupted import, and nothing if an import still holds it
+func removeStagingDir(staging string) error {
+	lock, err := os.Open(staging)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = lock.Close()
+	}()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err == syscall.EWOULDBLOCK {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return os.RemoveAll(staging)
+}
+
+// storeLayer extracts a layer into the store unless it is there already,
+// it is extracted to a staging dir first so that a failed import leaves nothing behind
+func storeLayer(layerPath, diffId string) error {
+	dest := layerDir(diffId)
+	if _, err := os.Stat(dest); err == nil {
+		return nil
+	}
+	staging, err := ioutil.TempDir(config.LayerPath, stagingPrefix)
+	if err != nil {
+		return fmt.Errorf("cannot create staging dir: %v", err)
+	}
+	if err := extractLayer(layerPath, diffId, staging); err != nil {
+		if err := os.RemoveAll(staging); err != nil {
+			log.Printf("Cannot clean up staging dir `%s`: %v", staging, err)
+		}
+		return err
+	}
+	// the staging dir is created with 0700
+	if err := os.Chmod(staging, 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(staging, dest); err != nil {
+		return fmt.Errorf("cannot move layer into place: %v", err)
+	}
+	return nil
+}
+
+// allMetadata returns the metadata of all layered images
+func allMetadata() ([]*Metadata, error) {
+	files, err := ioutil.ReadDir(config.ImageMetadataPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read dir %s: %v", config.ImageMetadataPath, err)
+	}
+	var all []*Metadata
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		metadata, err := LoadMetadata(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		if metadata != nil {
+			all = append(all, metadata)
+		}
+	}
+	return all, nil
+}
+
+// LayerReferences counts the images and the boxes referencing each stored layer, unreferenced layers count 0,
+// a box keeps the layers it stacks even once its image is removed
+func LayerReferences() (map[string]int, error) {
+	references := map[string]int{}
+	files, err := ioutil.ReadDir(config.LayerPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot read dir %s: %v", config.LayerPath, err)
+	}
+	for _, file := range files {
+		if file.IsDir() && !strings.HasPrefix(file.Name(), ".") {
+			references[digestPrefix+file.Name()] = 0
+		}
+	}
+
+	all, err := allMetadata()
+	if err != nil {
+		return nil, err
+	}
+	for _, metadata := range all {
+		// a layer listed twice by one image is still one reference
+		seen := map[string]bool{}
+		for _, diffId := range metadata.Layers {
+			if !seen[diffId] {
+				seen[diffId] = true
+				references[diffId]++
+			}
+		}
+	}
+
+	boxDirs, err := boxLayerDirs()
+	if err != nil {
+		return nil, err
+	}
+	for _, dirs := range boxDirs {
+		seen := map[string]bool{}
+		for _, dir := range dirs {
+			if path.Dir(dir) != path.Clean(config.LayerPath) || seen[dir] {
+				continue
+			}
+			seen[dir] = true
+			references[digestPrefix+path.Base(dir)]++
+		}
+	}
+	return references, nil
+}
+
+// Prune removes the layers no image or box references and the dirs of removed images no box stacks any more,
+// along with staging dirs left by interrupted imports, those of imports in progress are locked and kept
+func Prune() (*PruneReport, error) {
+	unlock, err := lockLayerStore()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	references, err := LayerReferences()
+	if err != nil {
+		return nil, err
+	}
+	report := &PruneReport{Layers: []string{}}
+	for diffId, count := range references {
+		if count > 0 {
+			continue
+		}
+		dir := layerDir(diffId)
+		size, err := dirSize(dir)
+		if err != nil {
+			return report, err
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return report, fmt.Errorf("cannot remove layer `%s`: %v", diffId, err)
+		}
+		report.Layers = append(report.Layers, diffId)
+		report.SpaceReclaimed += size
+	}
+
+	removedDirs, err := filepath.Glob(path.Join(config.ImagePath, removedPrefix+"*"))
+	if err != nil {
+		return report, err
+	}
+	used, err := usedDirs()
+	if err != nil {
+		return report, err
+	}
+	for _, dir := range removedDirs {
+		if used[dir] {
+			continue
+		}
+		size, err := dirSize(dir)
+		if err != nil {
+			return report, err
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return report, fmt.Errorf("cannot remove `%s`: %v", dir, err)
+		}
+		report.SpaceReclaimed += size
+	}
+
+	var stagings []string
+	for _, dir := range []string{config.LayerPath, config.ImagePath} {
+		matches, err := filepath.Glob(path.Join(dir, stagingPrefix+"*"))
+		if err != nil {
+			return report, err
+		}
+		stagings = append(stagings, matches...)
+	}
+	for _, staging := range stagings {
+		if err := removeStagingDir(staging); err != nil {
+			return report, fmt.Errorf("cannot remove staging dir `%s`: %v", staging, err)
+		}
+	}
+	return report, nil
+}
+
+// dirSize sums the sizes of the regular files under `dir`
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("cannot measure `%s`: %v", dir, err)
+	}
+	return size, nil
+}
@@ -3,36 +3,152 @@ package image
 import (
 	"fmt"
 	"github.com/yqszxx/oreo-box/config"
+	"github.com/yqszxx/oreo-box/internal"
 	"io/ioutil"
 	"os"
-	"text/tabwriter"
+	"path"
+	"sort"
+	"strings"
+	"time"
 )
 
-func List() error {
-	files, err := ioutil.ReadDir(config.ImagePath)
+// Detail describes an image as inspect and the image list show it
+type Detail struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+	// Size is the disk usage of the tree or the layers of the image in bytes, layers shared with other images count in full
+	Size int64 `json:"size"`
+	// Imported is taken from the files of images which did not record it
+	Imported time.Time `json:"imported"`
+	// Boxes are the names of the boxes created from the image
+	Boxes []string `json:"boxes"`
+	// Metadata is nil for images imported before metadata was recorded
+	Metadata *Metadata `json:"metadata,omitempty"`
+}
+
+// Inspect describes the image `reference` refers to
+func Inspect(reference string) (*Detail, error) {
+	imageName, err := Resolve(reference)
+	if err != nil {
+		return nil, err
+	}
+	boxInfos, err := internal.GetAllBoxInfos()
+	if err != nil {
+		return nil, err
+	}
+	return describe(imageName, boxInfos)
+}
+
+// List describes all images, or those `references` refer to
+func List(references ...string) ([]*Detail, error) {
+	names, err := Names(references...)
+	if err != nil {
+		return nil, err
+	}
+	boxInfos, err := internal.GetAllBoxInfos()
+	if err != nil {
+		return nil, err
+	}
+	var details []*Detail
+	for _, imageName := range names {
+		detail, err := describe(imageName, boxInfos)
+		if err != nil {
+			return nil, err
+		}
+		details = append(details, detail)
+	}
+	return details, nil
+}
+
+func describe(imageName string, boxInfos []*internal.BoxInfo) (*Detail, error) {
+	detail := &Detail{Name: imageName, Tags: []string{}, Boxes: []string{}}
+	tags, err := Tags(imageName)
+	if err != nil {
+		return nil, err
+	}
+	detail.Tags = append(detail.Tags, tags...)
+	for _, boxInfo := range boxInfos {
+		if boxInfo.Image == imageName {
+			detail.Boxes = append(detail.Boxes, boxInfo.Name)
+		}
+	}
+	if detail.Metadata, err = LoadMetadata(imageName); err != nil {
+		return nil, err
+	}
+
+	dirs, err := LayerDirs(imageName)
 	if err != nil {
-		return fmt.Errorf("cannot read dir %s: %v", config.ImagePath, err)
+		return nil, err
+	}
+	for _, dir := range dirs {
+		size, err := dirSize(dir)
+		if err != nil {
+			return nil, err
+		}
+		detail.Size += size
 	}
 
+	if detail.Metadata != nil && !detail.Metadata.Imported.IsZero() {
+		detail.Imported = detail.Metadata.Imported
+	} else {
+		filePath := metadataFilePath(imageName)
+		if detail.Metadata == nil {
+			filePath = path.Join(config.ImagePath, imageName)
+		}
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot stat image `%s`: %v", imageName, err)
+		}
+		detail.Imported = info.ModTime().UTC()
+	}
+	return detail, nil
+}
+
+// Names returns the names of all imported images, or of those `references` refer to,
+// layered images are known by their metadata
+func Names(references ...string) ([]string, error) {
+	if len(references) > 0 {
+		return resolveAll(references)
+	}
 	var images []string
+	files, err := ioutil.ReadDir(config.ImagePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot read dir %s: %v", config.ImagePath, err)
+	}
 	for _, file := range files {
-		if file.IsDir() {
+		// dot dirs are imports in progress
+		if file.IsDir() && !strings.HasPrefix(file.Name(), ".") {
 			images = append(images, file.Name())
 		}
 	}
 
-	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	if _, err := fmt.Fprint(w, "NAME\n"); err != nil {
-		return fmt.Errorf("fail to exec fmt.Fprint : %v", err)
+	all, err := allMetadata()
+	if err != nil {
+		return nil, err
 	}
-	for _, item := range images {
-		_, err := fmt.Fprintf(w, "%s\n", item)
-		if err != nil {
-			return fmt.Errorf("fail to exec fmt.Fprintf %v", err)
+	for _, metadata := range all {
+		// signed images have both a dir and metadata
+		if metadata.Format != FormatSigned {
+			images = append(images, metadata.Name)
 		}
 	}
-	if err := w.Flush(); err != nil {
-		return fmt.Errorf("cannot flush : %v", err)
+	sort.Strings(images)
+	return images, nil
+}
+
+func resolveAll(references []string) ([]string, error) {
+	var images []string
+	seen := map[string]bool{}
+	for _, reference := range references {
+		imageName, err := Resolve(reference)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[imageName] {
+			seen[imageName] = true
+			images = append(images, imageName)
+		}
 	}
-	return nil
+	sort.Strings(images)
+	return images, nil
 }
@@ -0,0 +1,134 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/yqszxx/oreo-box/config"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	FormatOci    = "oci"
+	FormatDocker = "docker"
+	// FormatSigned images are a single tree imported from `image.tar` + `signature.asc`
+	FormatSigned = "signed"
+)
+
+// Config is the default execution config of an image, the field names follow the OCI image spec
+type Config struct {
+	User         string              `json:"User,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+}
+
+// History describes how a layer was built
+type History struct {
+	Created    time.Time `json:"created,omitempty"`
+	CreatedBy  string    `json:"created_by,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	EmptyLayer bool      `json:"empty_layer,omitempty"`
+}
+
+// Metadata describes an image, images imported from the signed format before metadata was recorded have none
+type Metadata struct {
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	// Digest is the digest of the image config, which identifies the image
+	Digest string `json:"digest"`
+	// Signer is the fingerprint of the key which signed the image, empty if it was not verified
+	Signer  string    `json:"signer,omitempty"`
+	Created time.Time `json:"created,omitempty"`
+	// Imported is when the image entered the store, by import or commit
+	Imported     time.Time `json:"imported,omitempty"`
+	Architecture string    `json:"architecture,omitempty"`
+	Os           string    `json:"os,omitempty"`
+	Config       Config    `json:"config"`
+	// Layers are the digests of the uncompressed layers, bottom first
+	Layers  []string  `json:"layers"`
+	History []History `json:"history,omitempty"`
+}
+
+func metadataFilePath(imageName string) string {
+	return path.Join(config.ImageMetadataPath, imageName+".json")
+}
+
+// configBlobPath is where the config of a layered image is kept as imported, its digest identifies the image
+func configBlobPath(imageName string) string {
+	return path.Join(config.ImageMetadataPath, imageName+".config")
+}
+
+// signatureFilePath is where the detached signature of an image is kept, which signs the image data
+// of a signed image and the flattened tree of a committed one, both being what `Save` writes as `image.tar`
+func signatureFilePath(imageName string) string {
+	return path.Join(config.ImageMetadataPath, imageName+".asc")
+}
+
+// imageDataPath is where the verified `image.tar` of a signed image is kept if it was imported with `KeepArchive`
+func imageDataPath(imageName string) string {
+	return path.Join(config.ImageMetadataPath, imageName+".tar")
+}
+
+// LoadMetadata returns the metadata of an image, or nil if it has none
+func LoadMetadata(imageName string) (*Metadata, error) {
+	content, err := ioutil.ReadFile(metadataFilePath(imageName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read metadata of image `%s`: %v", imageName, err)
+	}
+	metadata := &Metadata{}
+	if err := json.Unmarshal(content, metadata); err != nil {
+		return nil, fmt.Errorf("cannot parse metadata of image `%s`: %v", imageName, err)
+	}
+	return metadata, nil
+}
+
+// Digest returns the digest identifying an image, which is empty for images without metadata
+func Digest(imageName string) (string, error) {
+	metadata, err := LoadMetadata(imageName)
+	if err != nil || metadata == nil {
+		return "", err
+	}
+	return metadata.Digest, nil
+}
+
+func saveMetadata(metadata *Metadata) error {
+	content, err := json.MarshalIndent(metadata, "", "    ")
+	if err != nil {
+		return err
+	}
+	// the metadata of a replaced image is swapped at once
+	if err := writeFileAtomic(metadataFilePath(metadata.Name), content); err != nil {
+		return fmt.Errorf("cannot write metadata of image `%s`: %v", metadata.Name, err)
+	}
+	return nil
+}
+
+// LayerDirs returns the layer dirs of an image, top first as they are stacked, or the image dir itself if it is not layered
+func LayerDirs(imageName string) ([]string, error) {
+	metadata, err := LoadMetadata(imageName)
+	if err != nil {
+		return nil, err
+	}
+	if metadata == nil || metadata.Format == FormatSigned {
+		return []string{path.Join(config.ImagePath, imageName)}, nil
+	}
+	return layerDirsOf(metadata.Layers), nil
+}
+
+// layerDirsOf returns the dirs of the layers `diffIds`, top first
+func layerDirsOf(diffIds []string) []string {
+	var dirs []string
+	for i := len(diffIds) - 1; i >= 0; i-- {
+		dirs = append(dirs, layerDir(diffIds[i]))
+	}
+	return dirs
+}
@@ -1 +1,296 @@
 package image
+
+import (
+	"fmt"
+	"github.com/yqszxx/oreo-box/config"
+	"github.com/yqszxx/oreo-box/internal"
+	"github.com/yqszxx/oreo-box/internal/events"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// NotFoundError is returned when the named image does not exist
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("no such image `%s`", e.Name)
+}
+
+// InUseError is returned when removing an image which boxes are created from
+type InUseError struct {
+	Name  string
+	Boxes []string
+}
+
+func (e *InUseError) Error() string {
+	return fmt.Sprintf("image `%s` is used by box %s", e.Name, strings.Join(e.Boxes, ", "))
+}
+
+// Exists tells whether an image named `imageName` has been imported
+func Exists(imageName string) (bool, error) {
+	if internal.Exist(path.Join(config.ImagePath, imageName), true) {
+		return true, nil
+	}
+	metadata, err := LoadMetadata(imageName)
+	if err != nil {
+		return false, err
+	}
+	return metadata != nil, nil
+}
+
+// Users returns the names of the boxes created from an image
+func Users(imageName string) ([]string, error) {
+	boxInfos, err := internal.GetAllBoxInfos()
+	if err != nil {
+		return nil, err
+	}
+	var boxes []string
+	for _, boxInfo := range boxInfos {
+		if boxInfo.Image == imageName {
+			boxes = append(boxes, boxInfo.Name)
+		}
+	}
+	return boxes, nil
+}
+
+// boxLayerDirs returns the image dirs each box stacks in its workspace,
+// boxes created before they were recorded stack those of their image if it still exists
+func boxLayerDirs() ([][]string, error) {
+	boxInfos, err := internal.GetAllBoxInfos()
+	if err != nil {
+		return nil, err
+	}
+	var all [][]string
+	for _, boxInfo := range boxInfos {
+		dirs := boxInfo.Layers
+		if len(dirs) == 0 && boxInfo.Image != "" {
+			exists, err := Exists(boxInfo.Image)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				if dirs, err = LayerDirs(boxInfo.Image); err != nil {
+					return nil, err
+				}
+			}
+		}
+		all = append(all, dirs)
+	}
+	return all, nil
+}
+
+// usedDirs returns the set of image dirs stacked by boxes
+func usedDirs() (map[string]bool, error) {
+	boxDirs, err := boxLayerDirs()
+	if err != nil {
+		return nil, err
+	}
+	used := map[string]bool{}
+	for _, dirs := range boxDirs {
+		for _, dir := range dirs {
+			used[dir] = true
+		}
+	}
+	return used, nil
+}
+
+// Remove removes an image by name or digest along with its tags, refusing to if boxes use it unless forced,
+// the layers of a layered image are removed unless other images share them. Forcing only overrides the refusal:
+// the dirs boxes stack are kept until those boxes are removed. Removing a tag only removes the tag
+func Remove(reference string, force bool) error {
+	if !strings.HasPrefix(reference, digestPrefix) {
+		imageName, tag, err := lookupTag(reference)
+		if err != nil {
+			return err
+		}
+		if tag != "" {
+			unlock, err := lockLayerStore()
+			if err != nil {
+				return err
+			}
+			defer unlock()
+			if err := removeTags(imageName, tag); err != nil {
+				return err
+			}
+			events.Log(events.TypeImage, "untag", imageName, tag, nil)
+			return nil
+		}
+	}
+	imageName, err := Resolve(reference)
+	if err != nil {
+		return err
+	}
+
+	if !force {
+		boxes, err := Users(imageName)
+		if err != nil {
+			return fmt.Errorf("cannot find boxes using image `%s`: %v", imageName, err)
+		}
+		if len(boxes) > 0 {
+			return &InUseError{Name: imageName, Boxes: boxes}
+		}
+	}
+
+	unlock, err := lockLayerStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := recordBoxLayers(imageName); err != nil {
+		return err
+	}
+	metadata, err := LoadMetadata(imageName)
+	if err != nil {
+		return err
+	}
+	if metadata != nil {
+		for _, filePath := range []string{configBlobPath(imageName), signatureFilePath(imageName), imageDataPath(imageName)} {
+			if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("cannot remove `%s`: %v", filePath, err)
+			}
+		}
+		if err := os.Remove(metadataFilePath(imageName)); err != nil {
+			return fmt.Errorf("cannot remove metadata of image `%s`: %v", imageName, err)
+		}
+		if err := removeUnreferencedLayers(metadata.Layers); err != nil {
+			return err
+		}
+	}
+	if err := removeTags(imageName, ""); err != nil {
+		return err
+	}
+
+	if err := removeImageDir(path.Join(config.ImagePath, imageName)); err != nil {
+		return err
+	}
+
+	events.Log(events.TypeImage, "remove", imageName, imageName, nil)
+	return nil
+}
+
+// recordBoxLayers records the dirs of an image in the info of boxes which were created from it before they recorded them,
+// so that the dirs are still known once the image is removed. The layer store must be locked
+func recordBoxLayers(imageName string) error {
+	boxInfos, err := internal.GetAllBoxInfos()
+	if err != nil {
+		return err
+	}
+	for _, boxInfo := range boxInfos {
+		if boxInfo.Image != imageName || len(boxInfo.Layers) > 0 {
+			continue
+		}
+		if boxInfo.Layers, err = LayerDirs(imageName); err != nil {
+			return err
+		}
+		if err := internal.WriteBoxInfo(boxInfo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeImageDir removes the dir of a removed image, or moves it aside while boxes stack it,
+// the layer store must be locked
+func removeImageDir(imageDataDir string) error {
+	if !internal.Exist(imageDataDir, true) {
+		return nil
+	}
+	boxInfos, err := internal.GetAllBoxInfos()
+	if err != nil {
+		return err
+	}
+	var users []*internal.BoxInfo
+	for _, boxInfo := range boxInfos {
+		for _, dir := range boxInfo.Layers {
+			if dir == imageDataDir {
+				users = append(users, boxInfo)
+				break
+			}
+		}
+	}
+	if len(users) == 0 {
+		if err := os.RemoveAll(imageDataDir); err != nil {
+			return fmt.Errorf("cannot remove image dir `%s`: %v", imageDataDir, err)
+		}
+		return nil
+	}
+
+	// the name is freed for another image while the mounted branch stays the same dir
+	removed := path.Join(config.ImagePath, fmt.Sprintf("%s%s-%d", removedPrefix, path.Base(imageDataDir), time.Now().UnixNano()))
+	if err := os.Rename(imageDataDir, removed); err != nil {
+		return fmt.Errorf("cannot move image dir `%s` aside: %v", imageDataDir, err)
+	}
+	for _, boxInfo := range users {
+		for i, dir := range boxInfo.Layers {
+			if dir == imageDataDir {
+				boxInfo.Layers[i] = removed
+			}
+		}
+		if err := internal.WriteBoxInfo(boxInfo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Release removes those of the dirs a removed box stacked which belong to images removed meanwhile,
+// unless other boxes still stack them
+func Release(layerDirs []string) error {
+	if len(layerDirs) == 0 {
+		return nil
+	}
+	unlock, err := lockLayerStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	references, err := LayerReferences()
+	if err != nil {
+		return err
+	}
+	used, err := usedDirs()
+	if err != nil {
+		return err
+	}
+	for _, dir := range layerDirs {
+		switch {
+		case path.Dir(dir) == path.Clean(config.LayerPath):
+			if references[digestPrefix+path.Base(dir)] > 0 {
+				continue
+			}
+		case path.Dir(dir) == path.Clean(config.ImagePath) && strings.HasPrefix(path.Base(dir), removedPrefix):
+			if used[dir] {
+				continue
+			}
+		default:
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("cannot remove `%s`: %v", dir, err)
+		}
+	}
+	return nil
+}
+
+// removeUnreferencedLayers removes those of `layers` no image or box references any more, the layer store must be locked
+func removeUnreferencedLayers(layers []string) error {
+	references, err := LayerReferences()
+	if err != nil {
+		return err
+	}
+	for _, diffId := range layers {
+		if references[diffId] > 0 {
+			continue
+		}
+		if err := os.RemoveAll(layerDir(diffId)); err != nil {
+			return fmt.Errorf("cannot remove layer `%s`: %v", diffId, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package image
+
+import (
+	"archive/tar"
+	"fmt"
+	"github.com/yqszxx/oreo-box/config"
+	"github.com/yqszxx/oreo-box/internal"
+	"golang.org/x/crypto/openpgp"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Save writes an image as `image.tar` + `signature.asc`, the format `Import` verifies. A signed image imported with
+// `KeepArchive` is written as imported unless `signKeyPath` is given, other images are flattened and must be signed,
+// either by `signKeyPath` or when they were committed
+func Save(reference string, w io.Writer, signKeyPath string) error {
+	imageName, err := Resolve(reference)
+	if err != nil {
+		return err
+	}
+	signer, err := readSignKey(signKeyPath)
+	if err != nil {
+		return err
+	}
+	metadata, err := LoadMetadata(imageName)
+	if err != nil {
+		return err
+	}
+
+	imageDataFilePath := imageDataPath(imageName)
+	kept := metadata != nil && metadata.Format == FormatSigned && internal.Exist(imageDataFilePath, false)
+	signed := internal.Exist(signatureFilePath(imageName), false)
+	if kept && signer == nil && signed {
+		return writeBundle(w, imageDataFilePath, signatureFilePath(imageName))
+	}
+	if signer == nil && !signed {
+		return fmt.Errorf("image `%s` has no signature to reuse, a signing key is required", imageName)
+	}
+
+	if err := os.MkdirAll(config.ImageTempPath, 0755); err != nil {
+		return fmt.Errorf("cannot create temp image dir `%s`: %v", config.ImageTempPath, err)
+	}
+	if !kept {
+		dataFile, err := ioutil.TempFile(config.ImageTempPath, "save-")
+		if err != nil {
+			return fmt.Errorf("cannot create image data file: %v", err)
+		}
+		imageDataFilePath = dataFile.Name()
+		defer func() {
+			if err := os.Remove(imageDataFilePath); err != nil {
+				panic(err)
+			}
+		}()
+		layerDirs, err := LayerDirs(imageName)
+		if err != nil {
+			_ = dataFile.Close()
+			return err
+		}
+		if err := WriteFlattened(layerDirs, dataFile); err != nil {
+			_ = dataFile.Close()
+			return err
+		}
+		if err := dataFile.Close(); err != nil {
+			return err
+		}
+	}
+	if signer == nil {
+		// a committed image was signed as it is flattened, which the layers reproduce as long as they are intact
+		if _, err := verifyDetachedSignature(imageDataFilePath, signatureFilePath(imageName)); err != nil {
+			return fmt.Errorf("cannot reuse the signature of image `%s`, a signing key is required: %v", imageName, err)
+		}
+		return writeBundle(w, imageDataFilePath, signatureFilePath(imageName))
+	}
+
+	signatureFile, err := ioutil.TempFile(config.ImageTempPath, "signature-")
+	if err != nil {
+		return fmt.Errorf("cannot create signature file: %v", err)
+	}
+	defer func() {
+		if err := os.Remove(signatureFile.Name()); err != nil {
+			panic(err)
+		}
+	}()
+	if err := signFile(signer, imageDataFilePath, signatureFile); err != nil {
+		_ = signatureFile.Close()
+		return err
+	}
+	if err := signatureFile.Close(); err != nil {
+		return err
+	}
+	return writeBundle(w, imageDataFilePath, signatureFile.Name())
+}
+
+func signFile(signer *openpgp.Entity, filePath string, w io.Writer) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			panic(err)
+		}
+	}()
+	if err := openpgp.ArmoredDetachSign(w, signer, file, nil); err != nil {
+		return fmt.Errorf("cannot sign image: %v", err)
+	}
+	return nil
+}
+
+// writeBundle writes the outer tarball of the signed format
+func writeBundle(w io.Writer, imageDataFilePath, signatureFilePath string) error {
+	tw := tar.NewWriter(w)
+	// the signature goes first so that the image can be verified while it is imported
+	if err := writeBundleFile(tw, config.SignatureFileName, signatureFilePath); err != nil {
+		return fmt.Errorf("cannot write `%s`: %v", config.SignatureFileName, err)
+	}
+	if err := writeBundleFile(tw, config.ImageDataFileName, imageDataFilePath); err != nil {
+		return fmt.Errorf("cannot write `%s`: %v", config.ImageDataFileName, err)
+	}
+	return tw.Close()
+}
+
+func writeBundleFile(tw *tar.Writer, name, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			panic(err)
+		}
+	}()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+	}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
@@ -0,0 +1,274 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/yqszxx/oreo-box/config"
+	"github.com/yqszxx/oreo-box/internal/events"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// images are referred to by the name they are stored under, by tags of the form `name:tag` where a missing tag
+// means `latest`, so that image `busybox` is also `busybox:latest`, or by their digest. The tag index maps each tag
+// to the name of its image
+
+// defaultTag is the tag of a reference which has none, an image is implicitly tagged with it under its own name
+const defaultTag = "latest"
+
+// digestPrefix starts references to an image by its digest
+const digestPrefix = "sha256:"
+
+func tagIndexPath() string {
+	return path.Join(config.ImagePath, config.TagIndexFileName)
+}
+
+func loadTags() (map[string]string, error) {
+	tags := map[string]string{}
+	content, err := ioutil.ReadFile(tagIndexPath())
+	if os.IsNotExist(err) {
+		return tags, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read tag index: %v", err)
+	}
+	if err := json.Unmarshal(content, &tags); err != nil {
+		return nil, fmt.Errorf("cannot parse tag index: %v", err)
+	}
+	return tags, nil
+}
+
+// splitReference splits `name[:tag]`, the tag defaults to `latest`
+func splitReference(reference string) (name, tag string) {
+	parts := strings.SplitN(reference, ":", 2)
+	if len(parts) == 1 {
+		return parts[0], defaultTag
+	}
+	return parts[0], parts[1]
+}
+
+func normalizeTag(reference string) string {
+	name, tag := splitReference(reference)
+	return name + ":" + tag
+}
+
+// validateName checks a name an image can be stored under, or the name part of a tag,
+// it becomes a path and must not hold `:` which separates the branches of the workspace mount
+func validateName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/:") || strings.HasPrefix(name, ".") || name+":" == digestPrefix {
+		return fmt.Errorf("invalid image name `%s`", name)
+	}
+	return nil
+}
+
+// MatchDigest matches a digest by itself, its hex part or a prefix of it of at least 12 characters
+func MatchDigest(digest, value string) bool {
+	if digest == "" {
+		return false
+	}
+	hex := strings.TrimPrefix(digest, digestPrefix)
+	value = strings.TrimPrefix(value, digestPrefix)
+	return value == hex || (len(value) >= 12 && strings.HasPrefix(hex, value))
+}
+
+// saveTags replaces the index at once so that readers never see it half written
+func saveTags(tags map[string]string) error {
+	content, err := json.MarshalIndent(tags, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(tagIndexPath(), content); err != nil {
+		return fmt.Errorf("cannot write tag index: %v", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes a file next to `filePath` and renames it over `filePath`
+func writeFileAtomic(filePath string, content []byte) error {
+	if err := os.MkdirAll(path.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(path.Dir(filePath), "."+path.Base(filePath)+"-")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return err
+	}
+	if err := file.Chmod(0644); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+	if err := os.Rename(file.Name(), filePath); err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+	return nil
+}
+
+// Tags returns the tags of an image as `name:tag`, sorted, the implicit `<image>:latest` is not among them
+func Tags(imageName string) ([]string, error) {
+	tags, err := loadTags()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for tag, target := range tags {
+		if target == imageName {
+			names = append(names, tag)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Resolve returns the name an image is stored under, given that name, one of its tags or its digest
+func Resolve(reference string) (string, error) {
+	if !strings.HasPrefix(reference, digestPrefix) {
+		imageName, _, err := lookupTag(reference)
+		if err != nil || imageName != "" {
+			return imageName, err
+		}
+	}
+	if imageName, err := lookupDigest(reference); err != nil || imageName != "" {
+		return imageName, err
+	}
+	return "", &NotFoundError{Name: reference}
+}
+
+// lookupTag returns the image `name[:tag]` refers to, along with the tag if it is one from the index
+func lookupTag(reference string) (imageName, tag string, err error) {
+	name, tagPart := splitReference(reference)
+	// dot dirs in the image store are not images
+	if validateName(name) != nil {
+		return "", "", nil
+	}
+	if tagPart == defaultTag {
+		if exists, err := Exists(name); err != nil {
+			return "", "", err
+		} else if exists {
+			return name, "", nil
+		}
+	}
+	tags, err := loadTags()
+	if err != nil {
+		return "", "", err
+	}
+	tag = name + ":" + tagPart
+	if target, ok := tags[tag]; ok {
+		return target, tag, nil
+	}
+	return "", "", nil
+}
+
+// lookupDigest returns the image whose digest `reference` matches, it fails if that is more than one
+func lookupDigest(reference string) (string, error) {
+	all, err := allMetadata()
+	if err != nil {
+		return "", err
+	}
+	var matched []string
+	for _, metadata := range all {
+		if MatchDigest(metadata.Digest, reference) {
+			matched = append(matched, metadata.Name)
+		}
+	}
+	if len(matched) > 1 {
+		sort.Strings(matched)
+		return "", fmt.Errorf("digest `%s` matches images %s", reference, strings.Join(matched, ", "))
+	}
+	if len(matched) == 1 {
+		return matched[0], nil
+	}
+	return "", nil
+}
+
+// validateTag checks that `tag` can name `imageName` and returns it as `name:tag`,
+// a tag moves from another image only if `force` is set
+func validateTag(tags map[string]string, tag, imageName string, force bool) (string, error) {
+	name, tagPart := splitReference(tag)
+	if err := validateName(name); err != nil {
+		return "", fmt.Errorf("invalid tag `%s`", tag)
+	}
+	if tagPart == "" || strings.ContainsAny(tagPart, "/:") {
+		return "", fmt.Errorf("invalid tag `%s`", tag)
+	}
+	if tagPart == defaultTag {
+		if exists, err := Exists(name); err != nil {
+			return "", err
+		} else if exists {
+			return "", fmt.Errorf("tag `%s` is the name of an image", tag)
+		}
+	}
+	normalized := name + ":" + tagPart
+	if target, ok := tags[normalized]; ok && target != imageName && !force {
+		return "", fmt.Errorf("tag `%s` already refers to image `%s`", normalized, target)
+	}
+	return normalized, nil
+}
+
+// Tag adds `tag` to the image `reference` refers to, moving it from another image only if `force` is set
+func Tag(reference, tag string, force bool) error {
+	imageName, err := Resolve(reference)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockLayerStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := addTags(imageName, []string{tag}, force); err != nil {
+		return err
+	}
+	events.Log(events.TypeImage, "tag", imageName, normalizeTag(tag), nil)
+	return nil
+}
+
+// addTags points `names` at `imageName`, the layer store must be locked
+func addTags(imageName string, names []string, force bool) error {
+	if len(names) == 0 {
+		return nil
+	}
+	tags, err := loadTags()
+	if err != nil {
+		return err
+	}
+	for _, tag := range names {
+		normalized, err := validateTag(tags, tag, imageName, force)
+		if err != nil {
+			return err
+		}
+		tags[normalized] = imageName
+	}
+	return saveTags(tags)
+}
+
+// removeTags drops the tags of an image, or a single tag if `tag` is not empty, the layer store must be locked
+func removeTags(imageName, tag string) error {
+	tags, err := loadTags()
+	if err != nil {
+		return err
+	}
+	changed := false
+	for name, target := range tags {
+		if target == imageName && (tag == "" || name == tag) {
+			delete(tags, name)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return saveTags(tags)
+}
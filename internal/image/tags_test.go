@@ -0,0 +1,138 @@
+package image
+
+import (
+	"github.com/yqszxx/oreo-box/config"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSplitReference(t *testing.T) {
+	for _, test := range []struct {
+		reference string
+		name, tag string
+	}{
+		{"busybox", "busybox", "latest"},
+		{"busybox:latest", "busybox", "latest"},
+		{"busybox:1.36", "busybox", "1.36"},
+		{"busybox:", "busybox", ""},
+		{":v1", "", "v1"},
+		{"a:b:c", "a", "b:c"},
+		{"", "", "latest"},
+	} {
+		t.Run(test.reference, func(t *testing.T) {
+			name, tag := splitReference(test.reference)
+			if name != test.name || tag != test.tag {
+				t.Errorf("got %q, %q, want %q, %q", name, tag, test.name, test.tag)
+			}
+		})
+	}
+}
+
+func TestNormalizeTag(t *testing.T) {
+	for _, test := range []struct {
+		reference, want string
+	}{
+		{"busybox", "busybox:latest"},
+		{"busybox:latest", "busybox:latest"},
+		{"busybox:1.36", "busybox:1.36"},
+	} {
+		if got := normalizeTag(test.reference); got != test.want {
+			t.Errorf("%q is normalized to %q, want %q", test.reference, got, test.want)
+		}
+	}
+}
+
+// imageStore creates an image store with the plain image `busybox`, the layered images `alpine` and `debian`
+// whose digests share 13 characters, and the tags `web:v1` and `web:latest`
+func imageStore(t *testing.T) (alpineDigest string) {
+	// the store lives at a fixed path, never touch one in use
+	if _, err := os.Stat(config.Root); err == nil {
+		t.Skipf("%s exists", config.Root)
+	}
+	if err := os.MkdirAll(path.Join(config.ImagePath, "busybox"), 0755); err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(config.Root)
+	})
+
+	alpineDigest = digestPrefix + "0123456789abc0" + strings.Repeat("0", 50)
+	for _, metadata := range []*Metadata{
+		{Name: "alpine", Format: FormatOci, Digest: alpineDigest},
+		{Name: "debian", Format: FormatOci, Digest: digestPrefix + "0123456789abc1" + strings.Repeat("0", 50)},
+	} {
+		if err := saveMetadata(metadata); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := saveTags(map[string]string{"web:v1": "alpine", "web:latest": "debian"}); err != nil {
+		t.Fatal(err)
+	}
+	return alpineDigest
+}
+
+func TestResolve(t *testing.T) {
+	alpineDigest := imageStore(t)
+	hex := strings.TrimPrefix(alpineDigest, digestPrefix)
+	for _, test := range []struct {
+		reference, want string
+	}{
+		{"busybox", "busybox"},
+		{"busybox:latest", "busybox"},
+		{"alpine", "alpine"},
+		{"web:v1", "alpine"},
+		{"web", "debian"},
+		{"web:latest", "debian"},
+		{alpineDigest, "alpine"},
+		{hex, "alpine"},
+		{hex[:14], "alpine"},
+		{digestPrefix + hex[:14], "alpine"},
+	} {
+		t.Run(test.reference, func(t *testing.T) {
+			got, err := Resolve(test.reference)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("resolved to %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestResolveNotFound(t *testing.T) {
+	alpineDigest := imageStore(t)
+	hex := strings.TrimPrefix(alpineDigest, digestPrefix)
+	for _, reference := range []string{
+		"",
+		"missing",
+		"busybox:old",
+		"web:v2",
+		".lock",
+		hex[:11],
+		digestPrefix + hex[:11],
+		digestPrefix + "busybox",
+	} {
+		t.Run(reference, func(t *testing.T) {
+			got, err := Resolve(reference)
+			if _, ok := err.(*NotFoundError); !ok {
+				t.Errorf("resolved to %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestResolveAmbiguousDigest(t *testing.T) {
+	alpineDigest := imageStore(t)
+	// both layered images start with these 13 characters
+	prefix := strings.TrimPrefix(alpineDigest, digestPrefix)[:13]
+	got, err := Resolve(prefix)
+	if err == nil {
+		t.Fatalf("resolved to %q", got)
+	}
+	if _, ok := err.(*NotFoundError); ok {
+		t.Errorf("ambiguous digest reported as missing: %v", err)
+	}
+}
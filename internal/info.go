@@ -1,18 +1,26 @@
 package internal
 
+import "github.com/yqszxx/oreo-box/internal/cgroup/subsystems"
+
 type BoxInfo struct {
-	Pid         string   `json:"pid"`
-	Id          string   `json:"id"`
-	Name        string   `json:"name"`
-	Command     string   `json:"command"`
-	CreatedTime string   `json:"createTime"`
-	Status      string   `json:"status"`
-	Volume      string   `json:"volume"`
-	PortMapping []string `json:"portMapping"`
+	Pid         string                     `json:"pid"`
+	Id          string                     `json:"id"`
+	Name        string                     `json:"name"`
+	Command     string                     `json:"command"`
+	Image       string                     `json:"image"`
+	ImageDigest string                     `json:"imageDigest"`
+	Layers      []string                   `json:"layers"`
+	CreatedTime string                     `json:"createTime"`
+	Status      string                     `json:"status"`
+	Volume      string                     `json:"volume"`
+	PortMapping []string                   `json:"portMapping"`
+	Network     string                     `json:"network"`
+	IPAddress   string                     `json:"ipAddress"`
+	Resources   *subsystems.ResourceConfig `json:"resources"`
 }
 
 const (
 	Running = "running"
 	Stopped = "stopped"
-	//Exited   = "exited"
+	Exited  = "exited"
 )
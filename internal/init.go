@@ -0,0 +1,41 @@
+package internal
+
+import "github.com/yqszxx/oreo-box/internal/cgroup/subsystems"
+
+// InitConfig is sent to the init process of a box through a pipe
+type InitConfig struct {
+	Args    []string             `json:"args"`
+	Devices []*subsystems.Device `json:"devices"`
+	// Cwd is created if it does not exist yet
+	Cwd string `json:"cwd,omitempty"`
+	// User is resolved against `/etc/passwd` and `/etc/group` of the box, as `user[:group]` by name or id
+	User string `json:"user,omitempty"`
+
+	// the fields below are only set for OCI bundles, a box pivots into its working directory
+	// and gets a fresh `/proc` and `/dev` instead
+
+	// Rootfs is bind mounted onto itself and becomes the root
+	Rootfs       string   `json:"rootfs,omitempty"`
+	ReadonlyRoot bool     `json:"readonlyRoot,omitempty"`
+	Mounts       []*Mount `json:"mounts,omitempty"`
+	Hostname     string   `json:"hostname,omitempty"`
+	Uid          uint32   `json:"uid,omitempty"`
+	Gid          uint32   `json:"gid,omitempty"`
+	// AdditionalGids are set as supplementary groups, which are otherwise cleared when Uid or Gid is set
+	AdditionalGids []uint32 `json:"additionalGids,omitempty"`
+	// WaitStart makes init block on the fifo passed as fd 4 until the box is started
+	WaitStart bool `json:"waitStart,omitempty"`
+	// Silent stops init from logging, so that the output of the box is its own
+	Silent bool `json:"silent,omitempty"`
+}
+
+// Mount is mounted under Rootfs by init before pivoting into it
+type Mount struct {
+	Source      string  `json:"source"`
+	Destination string  `json:"destination"`
+	Type        string  `json:"type"`
+	Flags       uintptr `json:"flags"`
+	// PropagationFlags are applied one by one after mounting, e.g. MS_PRIVATE
+	PropagationFlags []uintptr `json:"propagationFlags,omitempty"`
+	Data             string    `json:"data,omitempty"`
+}
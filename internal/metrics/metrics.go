@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"fmt"
+	"github.com/yqszxx/oreo-box/internal"
+	"github.com/yqszxx/oreo-box/internal/cgroup"
+	"github.com/yqszxx/oreo-box/internal/image"
+	"github.com/yqszxx/oreo-box/internal/network"
+	"io"
+	"log"
+	"sort"
+	"strings"
+)
+
+const namespace = "oreobox"
+
+type sample struct {
+	labels [][2]string
+	value  float64
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	samples []sample
+}
+
+func (f *family) add(value float64, labels ...[2]string) {
+	f.samples = append(f.samples, sample{labels: labels, value: value})
+}
+
+func (f *family) write(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "# HELP %s_%s %s\n# TYPE %s_%s %s\n", namespace, f.name, f.help, namespace, f.name, f.kind); err != nil {
+		return err
+	}
+	for _, s := range f.samples {
+		var labels []string
+		for _, label := range s.labels {
+			labels = append(labels, fmt.Sprintf("%s=%q", label[0], label[1]))
+		}
+		labelStr := ""
+		if len(labels) > 0 {
+			labelStr = "{" + strings.Join(labels, ",") + "}"
+		}
+		if _, err := fmt.Fprintf(w, "%s_%s%s %v\n", namespace, f.name, labelStr, s.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func label(name, value string) [2]string {
+	return [2]string{name, value}
+}
+
+// Write collects the metrics of all boxes, images and networks and writes them in Prometheus text format
+func Write(w io.Writer) error {
+	boxes, err := internal.GetAllBoxInfos()
+	if err != nil {
+		return err
+	}
+
+	boxCount := &family{name: "boxes", help: "Number of boxes by status.", kind: "gauge"}
+	statusCount := map[string]int{internal.Running: 0, internal.Stopped: 0}
+	for _, box := range boxes {
+		statusCount[box.Status]++
+	}
+	var statuses []string
+	for status := range statusCount {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		boxCount.add(float64(statusCount[status]), label("status", status))
+	}
+
+	families := append([]*family{boxCount}, boxFamilies(boxes)...)
+
+	images, err := image.Names()
+	if err != nil {
+		return err
+	}
+	imageCount := &family{name: "images", help: "Number of imported images.", kind: "gauge"}
+	imageCount.add(float64(len(images)))
+	families = append(families, imageCount)
+
+	ipamFamilies, err := networkFamilies()
+	if err != nil {
+		return err
+	}
+	families = append(families, ipamFamilies...)
+
+	for _, f := range families {
+		if err := f.write(w); err != nil {
+			return fmt.Errorf("cannot write metric %s: %v", f.name, err)
+		}
+	}
+	return nil
+}
+
+// boxFamilies reads the cgroup and network counters of every running box
+func boxFamilies(boxes []*internal.BoxInfo) []*family {
+	cpu := &family{name: "box_cpu_usage_seconds_total", help: "CPU time consumed by the box.", kind: "counter"}
+	memUsage := &family{name: "box_memory_usage_bytes", help: "Memory used by the box.", kind: "gauge"}
+	memLimit := &family{name: "box_memory_limit_bytes", help: "Memory limit of the box, 0 for unlimited.", kind: "gauge"}
+	pids := &family{name: "box_pids", help: "Number of processes in the box.", kind: "gauge"}
+	pidsLimit := &family{name: "box_pids_limit", help: "Maximum number of processes in the box, -1 for unlimited.", kind: "gauge"}
+	blkioRead := &family{name: "box_blkio_read_bytes_total", help: "Bytes read from block devices.", kind: "counter"}
+	blkioWrite := &family{name: "box_blkio_write_bytes_total", help: "Bytes written to block devices.", kind: "counter"}
+	netRx := &family{name: "box_network_receive_bytes_total", help: "Bytes received by the box.", kind: "counter"}
+	netTx := &family{name: "box_network_transmit_bytes_total", help: "Bytes transmitted by the box.", kind: "counter"}
+
+	for _, box := range boxes {
+		if box.Status != internal.Running {
+			continue
+		}
+		stats, err := cgroup.NewCgroupManager(box.Id).GetStats()
+		if err != nil {
+			log.Printf("cannot get stats of box `%s`: %v", box.Name, err)
+			continue
+		}
+		labels := [][2]string{label("id", box.Id), label("name", box.Name)}
+
+		cpu.add(float64(stats.CpuUsageNs)/1e9, labels...)
+		memUsage.add(float64(stats.MemoryUsage), labels...)
+		memLimit.add(float64(stats.MemoryLimit), labels...)
+		if stats.Pids != nil {
+			pids.add(float64(stats.Pids.Current), labels...)
+			pidsLimit.add(float64(stats.Pids.Limit), labels...)
+		}
+		blkioRead.add(float64(stats.BlkioRead), labels...)
+		blkioWrite.add(float64(stats.BlkioWrite), labels...)
+
+		if box.Network != "" {
+			networkStats, err := network.EndpointStats(box.Id, box.Network)
+			if err != nil {
+				log.Printf("cannot get network stats of box `%s`: %v", box.Name, err)
+				continue
+			}
+			netLabels := append(labels, label("network", box.Network))
+			netRx.add(float64(networkStats.RxBytes), netLabels...)
+			netTx.add(float64(networkStats.TxBytes), netLabels...)
+		}
+	}
+
+	return []*family{cpu, memUsage, memLimit, pids, pidsLimit, blkioRead, blkioWrite, netRx, netTx}
+}
+
+func networkFamilies() ([]*family, error) {
+	if err := network.Init(); err != nil {
+		return nil, fmt.Errorf("cannot init network controller: %v", err)
+	}
+
+	allocated := &family{name: "network_ipam_allocated_addresses", help: "Addresses allocated in the network, including the gateway.", kind: "gauge"}
+	capacity := &family{name: "network_ipam_capacity_addresses", help: "Size of the subnet of the network.", kind: "gauge"}
+	for _, nw := range network.Networks() {
+		used, total, err := network.IpamUsage(nw)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get ipam usage of network `%s`: %v", nw.Name, err)
+		}
+		allocated.add(float64(used), label("network", nw.Name))
+		capacity.add(float64(total), label("network", nw.Name))
+	}
+	return []*family{allocated, capacity}, nil
+}
@@ -53,7 +53,7 @@ func (d *BridgeNetworkDriver) Connect(network *Network, endpoint *Endpoint) erro
 	}
 
 	la := netlink.NewLinkAttrs()
-	la.Name = endpoint.ID[:5]
+	la.Name = endpointDeviceName(endpoint.ID)
 	la.MasterIndex = br.Attrs().Index
 
 	endpoint.Device = netlink.Veth{
@@ -71,6 +71,11 @@ func (d *BridgeNetworkDriver) Connect(network *Network, endpoint *Endpoint) erro
 	return nil
 }
 
+// endpointDeviceName is the name of the host side of the veth pair of an endpoint
+func endpointDeviceName(endpointID string) string {
+	return endpointID[:5]
+}
+
 func (d *BridgeNetworkDriver) Disconnect(Network, *Endpoint) error {
 	return nil
 }
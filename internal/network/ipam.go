@@ -148,3 +148,17 @@ func (ipam *IPAM) Release(subnet *net.IPNet, ipaddr *net.IP) error {
 	}
 	return nil
 }
+
+// Usage returns the number of allocated addresses in `subnet` and its size
+func (ipam *IPAM) Usage(subnet *net.IPNet) (int, int, error) {
+	ipam.Subnets = &map[string]string{}
+
+	if err := ipam.load(); err != nil {
+		return 0, 0, fmt.Errorf("cannot load allocation info: %v", err)
+	}
+
+	_, subnet, _ = net.ParseCIDR(subnet.String())
+	one, size := subnet.Mask.Size()
+	allocated := strings.Count((*ipam.Subnets)[subnet.String()], "1")
+	return allocated, 1 << uint8(size-one), nil
+}
@@ -7,19 +7,25 @@ import (
 	"github.com/vishvananda/netns"
 	"github.com/yqszxx/oreo-box/config"
 	"github.com/yqszxx/oreo-box/internal"
+	"github.com/yqszxx/oreo-box/internal/events"
+	"io/ioutil"
+	"log"
 	"net"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
-	"text/tabwriter"
+	"sync"
 )
 
 var (
 	drivers  = map[string]Driver{}
 	networks = map[string]*Network{}
+	// mu guards the maps above and the ipam file, the daemon calls in from many goroutines
+	mu sync.Mutex
 )
 
 type Endpoint struct {
@@ -116,8 +122,13 @@ func (nw *Network) load(dumpPath string) error {
 }
 
 func Init() error {
+	mu.Lock()
+	defer mu.Unlock()
+
 	var bridgeDriver = BridgeNetworkDriver{}
 	drivers[bridgeDriver.Name()] = &bridgeDriver
+	// start from scratch, networks may have been removed since the last call
+	networks = map[string]*Network{}
 
 	if _, err := os.Stat(config.NetworkPath); err != nil {
 		if os.IsNotExist(err) {
@@ -153,38 +164,63 @@ func Init() error {
 }
 
 func CreateNetwork(driver, subnet, name string) error {
-	_, cidr, _ := net.ParseCIDR(subnet)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := networks[name]; ok {
+		return fmt.Errorf("network `%s` already exists", name)
+	}
+	d, ok := drivers[driver]
+	if !ok {
+		return fmt.Errorf("unknown network driver `%s`", driver)
+	}
+	_, cidr, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return fmt.Errorf("invalid subnet `%s`: %v", subnet, err)
+	}
 	ip, err := ipAllocator.Allocate(cidr)
 	if err != nil {
 		return err
 	}
 	cidr.IP = ip
 
-	nw, err := drivers[driver].Create(cidr.String(), name)
+	nw, err := d.Create(cidr.String(), name)
 	if err != nil {
 		return err
 	}
 
-	return nw.dump(config.NetworkPath)
+	if err := nw.dump(config.NetworkPath); err != nil {
+		return err
+	}
+	networks[name] = nw
+	events.Log(events.TypeNetwork, "create", name, name, map[string]string{"driver": driver, "subnet": cidr.String()})
+	return nil
 }
 
-func ListNetwork() error {
-	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	_, _ = fmt.Fprint(w, "NAME\tIpRange\tDriver\n")
+// Networks returns the networks loaded by `Init` and created since
+func Networks() []*Network {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var nws []*Network
 	for _, nw := range networks {
-		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n",
-			nw.Name,
-			nw.IpRange.String(),
-			nw.Driver,
-		)
+		nws = append(nws, nw)
 	}
-	if err := w.Flush(); err != nil {
-		return fmt.Errorf("cannot flush %v", err)
-	}
-	return nil
+	return nws
+}
+
+// IpamUsage returns the number of allocated addresses of a network and its capacity
+func IpamUsage(nw *Network) (int, int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return ipAllocator.Usage(nw.IpRange)
 }
 
 func DeleteNetwork(networkName string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
 	nw, ok := networks[networkName]
 	if !ok {
 		return fmt.Errorf("cannot find network `%s`", networkName)
@@ -198,7 +234,12 @@ func DeleteNetwork(networkName string) error {
 		return fmt.Errorf("cannot delete network driver: %s", err)
 	}
 
-	return nw.remove(config.NetworkPath)
+	if err := nw.remove(config.NetworkPath); err != nil {
+		return err
+	}
+	delete(networks, networkName)
+	events.Log(events.TypeNetwork, "remove", networkName, networkName, nil)
+	return nil
 }
 
 func enterboxNetns(enLink *netlink.Link, cinfo *internal.BoxInfo) (func() error, error) {
@@ -283,14 +324,27 @@ func configEndpointIpAddressAndRoute(ep *Endpoint, cinfo *internal.BoxInfo) erro
 	return nil
 }
 
+// portMappingRule returns the iptables rule of a port mapping without its command
+func portMappingRule(pm string, ip net.IP) (string, error) {
+	portMapping := strings.Split(pm, ":")
+	if len(portMapping) != 2 {
+		return "", fmt.Errorf("port mapping format error, %v", pm)
+	}
+	return fmt.Sprintf("PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
+		portMapping[0], ip.String(), portMapping[1]), nil
+}
+
 func configPortMapping(ep *Endpoint) error {
 	for _, pm := range ep.PortMapping {
-		portMapping := strings.Split(pm, ":")
-		if len(portMapping) != 2 {
-			return fmt.Errorf("port mapping format error, %v", pm)
+		rule, err := portMappingRule(pm, ep.IPAddress)
+		if err != nil {
+			return err
 		}
-		iptablesCmd := fmt.Sprintf("-t nat -A PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
-			portMapping[0], ep.IPAddress.String(), portMapping[1])
+		// a box started again keeps its address, and so its rules
+		if exec.Command("iptables", strings.Split("-t nat -C "+rule, " ")...).Run() == nil {
+			continue
+		}
+		iptablesCmd := "-t nat -A " + rule
 		cmd := exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
 		//err := cmd.Run()
 		output, err := cmd.Output()
@@ -301,29 +355,43 @@ func configPortMapping(ep *Endpoint) error {
 	return nil
 }
 
+// Connect connects a box to a network with the address in `cinfo.IPAddress`, a box connected the first time
+// is allocated one which is recorded there, it stays allocated until `Disconnect`
 func Connect(networkName string, cinfo *internal.BoxInfo) error {
+	mu.Lock()
+	defer mu.Unlock()
+
 	network, ok := networks[networkName]
 	if !ok {
 		return fmt.Errorf("cannot find network `%s`", networkName)
 	}
 
-	ip, err := ipAllocator.Allocate(network.IpRange)
-	if err != nil {
-		return err
+	var ip net.IP
+	if cinfo.IPAddress != "" {
+		ip = net.ParseIP(cinfo.IPAddress).To4()
+		if ip == nil || !network.IpRange.Contains(ip) {
+			return fmt.Errorf("address `%s` is not in network `%s`", cinfo.IPAddress, networkName)
+		}
+	} else {
+		var err error
+		if ip, err = ipAllocator.Allocate(network.IpRange); err != nil {
+			return err
+		}
+		cinfo.IPAddress = ip.String()
 	}
 
 	ep := &Endpoint{
-		ID:          fmt.Sprintf("%s-%s", cinfo.Id, networkName),
+		ID:          endpointID(cinfo.Id, networkName),
 		IPAddress:   ip,
 		Network:     network,
 		PortMapping: cinfo.PortMapping,
 	}
 
-	if err = drivers[network.Driver].Connect(network, ep); err != nil {
+	if err := drivers[network.Driver].Connect(network, ep); err != nil {
 		return err
 	}
 
-	if err = configEndpointIpAddressAndRoute(ep, cinfo); err != nil {
+	if err := configEndpointIpAddressAndRoute(ep, cinfo); err != nil {
 		return err
 	}
 
@@ -333,3 +401,67 @@ func Connect(networkName string, cinfo *internal.BoxInfo) error {
 
 	return nil
 }
+
+// Disconnect removes the port mappings of a removed box and releases its address,
+// nothing is left to do once the network is removed
+func Disconnect(networkName string, cinfo *internal.BoxInfo) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	network, ok := networks[networkName]
+	if !ok || cinfo.IPAddress == "" {
+		return nil
+	}
+	ip := net.ParseIP(cinfo.IPAddress).To4()
+	if ip == nil || !network.IpRange.Contains(ip) {
+		return fmt.Errorf("address `%s` is not in network `%s`", cinfo.IPAddress, networkName)
+	}
+	for _, pm := range cinfo.PortMapping {
+		rule, err := portMappingRule(pm, ip)
+		if err != nil {
+			return err
+		}
+		if output, err := exec.Command("iptables", strings.Split("-t nat -D "+rule, " ")...).CombinedOutput(); err != nil {
+			log.Printf("Cannot remove port mapping `%s` of box `%s`: %s", pm, cinfo.Name, output)
+		}
+	}
+	if err := ipAllocator.Release(network.IpRange, &ip); err != nil {
+		return fmt.Errorf("cannot release address `%s`: %v", cinfo.IPAddress, err)
+	}
+	return nil
+}
+
+func endpointID(boxID, networkName string) string {
+	return fmt.Sprintf("%s-%s", boxID, networkName)
+}
+
+// NetworkStats counts the traffic of a box as seen from inside the box
+type NetworkStats struct {
+	RxBytes   uint64 `json:"rxBytes"`
+	TxBytes   uint64 `json:"txBytes"`
+	RxPackets uint64 `json:"rxPackets"`
+	TxPackets uint64 `json:"txPackets"`
+}
+
+// EndpointStats reads the counters of the host side veth of a box, whose directions are the reverse of the box's
+func EndpointStats(boxID, networkName string) (*NetworkStats, error) {
+	device := endpointDeviceName(endpointID(boxID, networkName))
+	counters := map[string]*uint64{}
+	stats := &NetworkStats{}
+	counters["rx_bytes"] = &stats.TxBytes
+	counters["tx_bytes"] = &stats.RxBytes
+	counters["rx_packets"] = &stats.TxPackets
+	counters["tx_packets"] = &stats.RxPackets
+
+	for counter, value := range counters {
+		counterPath := path.Join("/sys/class/net", device, "statistics", counter)
+		content, err := ioutil.ReadFile(counterPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read %s: %v", counterPath, err)
+		}
+		if *value, err = strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64); err != nil {
+			return nil, fmt.Errorf("cannot parse %s: %v", counterPath, err)
+		}
+	}
+	return stats, nil
+}
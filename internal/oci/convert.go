@@ -0,0 +1,168 @@
+package oci
+
+import (
+	"fmt"
+	"github.com/yqszxx/oreo-box/internal/cgroup/subsystems"
+	"os"
+	"syscall"
+)
+
+var namespaceFlags = map[string]uintptr{
+	"pid":     syscall.CLONE_NEWPID,
+	"network": syscall.CLONE_NEWNET,
+	"mount":   syscall.CLONE_NEWNS,
+	"ipc":     syscall.CLONE_NEWIPC,
+	"uts":     syscall.CLONE_NEWUTS,
+	"cgroup":  syscall.CLONE_NEWCGROUP,
+}
+
+// cloneFlags returns the namespaces to create for the init process
+func cloneFlags(namespaces []LinuxNamespace) (uintptr, error) {
+	var flags uintptr
+	for _, ns := range namespaces {
+		if ns.Path != "" {
+			return 0, fmt.Errorf("joining the existing %s namespace `%s` is not supported", ns.Type, ns.Path)
+		}
+		flag, ok := namespaceFlags[ns.Type]
+		if !ok {
+			return 0, fmt.Errorf("%s namespace is not supported", ns.Type)
+		}
+		flags |= flag
+	}
+	// init pivots into the root, which must not affect the host
+	if flags&syscall.CLONE_NEWNS == 0 {
+		return 0, fmt.Errorf("a mount namespace is required")
+	}
+	return flags, nil
+}
+
+func wildcard(n *int64) int64 {
+	if n == nil {
+		return subsystems.Wildcard
+	}
+	return *n
+}
+
+// ConvertResources maps `linux.resources` onto the resource config of a box,
+// the device rules of the bundle come first so that the devices every box has cannot be denied
+func ConvertResources(resources *LinuxResources) (*subsystems.ResourceConfig, error) {
+	resConf := &subsystems.ResourceConfig{}
+	if resources == nil {
+		resources = &LinuxResources{}
+	}
+
+	for _, device := range resources.Devices {
+		rule := &subsystems.DeviceRule{
+			Type:   device.Type,
+			Major:  wildcard(device.Major),
+			Minor:  wildcard(device.Minor),
+			Access: device.Access,
+			Allow:  device.Allow,
+		}
+		if rule.Type == "" {
+			rule.Type = subsystems.AllDevices
+		}
+		if rule.Access == "" {
+			rule.Access = "rwm"
+		}
+		resConf.Devices = append(resConf.Devices, rule)
+	}
+	resConf.Devices = append(resConf.Devices, subsystems.DefaultDeviceRules...)
+
+	if memory := resources.Memory; memory != nil {
+		if memory.Limit != nil && *memory.Limit > 0 {
+			resConf.MemoryLimit = *memory.Limit
+		}
+		if memory.Reservation != nil && *memory.Reservation > 0 {
+			resConf.MemoryReservation = *memory.Reservation
+		}
+		// both are memory plus swap
+		if memory.Swap != nil && (*memory.Swap > 0 || *memory.Swap == -1) {
+			resConf.MemorySwap = *memory.Swap
+		}
+	}
+
+	if cpu := resources.CPU; cpu != nil {
+		if cpu.Shares != nil {
+			resConf.CpuShare = *cpu.Shares
+		}
+		if cpu.Period != nil {
+			resConf.CpuPeriodUs = *cpu.Period
+		}
+		if cpu.Quota != nil && (*cpu.Quota > 0 || *cpu.Quota == -1) {
+			resConf.CpuQuotaUs = *cpu.Quota
+		}
+		resConf.CpuSetCpus = cpu.Cpus
+		resConf.CpuSetMems = cpu.Mems
+	}
+
+	if pids := resources.Pids; pids != nil {
+		// 0 and negative values are unlimited in the spec
+		resConf.PidsLimit = pids.Limit
+		if pids.Limit <= 0 {
+			resConf.PidsLimit = -1
+		}
+	}
+
+	if blockIO := resources.BlockIO; blockIO != nil {
+		if blockIO.Weight != nil {
+			resConf.BlkioWeight = *blockIO.Weight
+		}
+		resConf.BlkioDeviceReadBps = throttleDevices(blockIO.ThrottleReadBpsDevice)
+		resConf.BlkioDeviceWriteBps = throttleDevices(blockIO.ThrottleWriteBpsDevice)
+		resConf.BlkioDeviceReadIOps = throttleDevices(blockIO.ThrottleReadIOPSDevice)
+		resConf.BlkioDeviceWriteIOps = throttleDevices(blockIO.ThrottleWriteIOPSDevice)
+	}
+
+	if err := resConf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid linux.resources: %v", err)
+	}
+	return resConf, nil
+}
+
+func throttleDevices(devices []LinuxThrottleDevice) []*subsystems.ThrottleDevice {
+	var throttles []*subsystems.ThrottleDevice
+	for _, device := range devices {
+		throttles = append(throttles, &subsystems.ThrottleDevice{
+			Major: device.Major,
+			Minor: device.Minor,
+			Rate:  device.Rate,
+		})
+	}
+	return throttles
+}
+
+// convertDevices returns the device nodes to create, the nodes every box has come first
+func convertDevices(devices []LinuxDevice) ([]*subsystems.Device, error) {
+	nodes := append([]*subsystems.Device{}, subsystems.DefaultDevices...)
+	for _, device := range devices {
+		node := &subsystems.Device{
+			DeviceRule: subsystems.DeviceRule{
+				Type:   device.Type,
+				Major:  device.Major,
+				Minor:  device.Minor,
+				Access: "rwm",
+				Allow:  true,
+			},
+			Path:     device.Path,
+			FileMode: 0666,
+		}
+		if node.Type == "u" {
+			node.Type = subsystems.CharDevice
+		}
+		if node.Type != subsystems.CharDevice && node.Type != subsystems.BlockDevice {
+			return nil, fmt.Errorf("device `%s` of type `%s` is not supported", device.Path, device.Type)
+		}
+		if device.FileMode != nil {
+			node.FileMode = os.FileMode(*device.FileMode).Perm()
+		}
+		if device.UID != nil {
+			node.Uid = *device.UID
+		}
+		if device.GID != nil {
+			node.Gid = *device.GID
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
@@ -0,0 +1,91 @@
+package oci
+
+import (
+	"github.com/yqszxx/oreo-box/internal"
+	"github.com/yqszxx/oreo-box/internal/cgroup/subsystems"
+	"strings"
+	"syscall"
+)
+
+type mountFlag struct {
+	clear bool
+	flag  uintptr
+}
+
+var mountFlags = map[string]mountFlag{
+	"async":         {true, syscall.MS_SYNCHRONOUS},
+	"atime":         {true, syscall.MS_NOATIME},
+	"bind":          {false, syscall.MS_BIND},
+	"defaults":      {false, 0},
+	"dev":           {true, syscall.MS_NODEV},
+	"diratime":      {true, syscall.MS_NODIRATIME},
+	"dirsync":       {false, syscall.MS_DIRSYNC},
+	"exec":          {true, syscall.MS_NOEXEC},
+	"mand":          {false, syscall.MS_MANDLOCK},
+	"noatime":       {false, syscall.MS_NOATIME},
+	"nodev":         {false, syscall.MS_NODEV},
+	"nodiratime":    {false, syscall.MS_NODIRATIME},
+	"noexec":        {false, syscall.MS_NOEXEC},
+	"nomand":        {true, syscall.MS_MANDLOCK},
+	"norelatime":    {true, syscall.MS_RELATIME},
+	"nostrictatime": {true, syscall.MS_STRICTATIME},
+	"nosuid":        {false, syscall.MS_NOSUID},
+	"rbind":         {false, syscall.MS_BIND | syscall.MS_REC},
+	"relatime":      {false, syscall.MS_RELATIME},
+	"remount":       {false, syscall.MS_REMOUNT},
+	"ro":            {false, syscall.MS_RDONLY},
+	"rw":            {true, syscall.MS_RDONLY},
+	"strictatime":   {false, syscall.MS_STRICTATIME},
+	"suid":          {true, syscall.MS_NOSUID},
+	"sync":          {false, syscall.MS_SYNCHRONOUS},
+}
+
+var propagationFlags = map[string]uintptr{
+	"private":     syscall.MS_PRIVATE,
+	"rprivate":    syscall.MS_PRIVATE | syscall.MS_REC,
+	"shared":      syscall.MS_SHARED,
+	"rshared":     syscall.MS_SHARED | syscall.MS_REC,
+	"slave":       syscall.MS_SLAVE,
+	"rslave":      syscall.MS_SLAVE | syscall.MS_REC,
+	"unbindable":  syscall.MS_UNBINDABLE,
+	"runbindable": syscall.MS_UNBINDABLE | syscall.MS_REC,
+}
+
+// convertMount splits the options of a mount into flags, propagation flags and the data passed to the filesystem
+func convertMount(m Mount) *internal.Mount {
+	mount := &internal.Mount{
+		Source:      m.Source,
+		Destination: m.Destination,
+		Type:        m.Type,
+	}
+	var data []string
+	for _, option := range m.Options {
+		if f, ok := mountFlags[option]; ok {
+			if f.clear {
+				mount.Flags &^= f.flag
+			} else {
+				mount.Flags |= f.flag
+			}
+		} else if f, ok := propagationFlags[option]; ok {
+			mount.PropagationFlags = append(mount.PropagationFlags, f)
+		} else {
+			data = append(data, option)
+		}
+	}
+	switch m.Type {
+	case "bind":
+		// a bind mount may be given as `"type": "bind"` without the option
+		mount.Flags |= syscall.MS_BIND
+	case "cgroup":
+		// v1 hierarchies cannot be mounted afresh from inside the box, show the ones of the host instead
+		if subsystems.IsCgroup2UnifiedMode() {
+			mount.Type = "cgroup2"
+			mount.Source = "cgroup2"
+		} else {
+			mount.Source = "/sys/fs/cgroup"
+			mount.Flags |= syscall.MS_BIND | syscall.MS_REC
+		}
+	}
+	mount.Data = strings.Join(data, ",")
+	return mount
+}
@@ -0,0 +1,389 @@
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/yqszxx/oreo-box/internal"
+	"github.com/yqszxx/oreo-box/internal/cgroup"
+	"github.com/yqszxx/oreo-box/internal/cgroup/subsystems"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	stateFileName = "state.json"
+	// init blocks reading the fifo until `start` writes to it
+	execFifoName = "exec.fifo"
+)
+
+const (
+	StatusCreating = "creating"
+	StatusCreated  = "created"
+	StatusRunning  = "running"
+	StatusStopped  = "stopped"
+)
+
+// State is the state of a container as defined by the runtime spec
+type State struct {
+	OciVersion  string            `json:"ociVersion"`
+	Id          string            `json:"id"`
+	Status      string            `json:"status"`
+	Pid         int               `json:"pid,omitempty"`
+	Bundle      string            `json:"bundle"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// record is what the runtime keeps about a container between invocations
+type record struct {
+	Id          string            `json:"id"`
+	Pid         int               `json:"pid"`
+	Bundle      string            `json:"bundle"`
+	CgroupPath  string            `json:"cgroupPath"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Created     time.Time         `json:"created"`
+}
+
+// Runtime manages containers whose state lives under Root
+type Runtime struct {
+	Root string
+}
+
+func (r *Runtime) stateDir(id string) string {
+	return filepath.Join(r.Root, id)
+}
+
+func validateId(id string) error {
+	if id == "" || id == "." || id == ".." || filepath.Base(id) != id {
+		return fmt.Errorf("invalid container id `%s`", id)
+	}
+	return nil
+}
+
+func (r *Runtime) load(id string) (*record, error) {
+	if err := validateId(id); err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(filepath.Join(r.stateDir(id), stateFileName))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("container `%s` does not exist", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read state of container `%s`: %v", id, err)
+	}
+	rec := &record{}
+	if err := json.Unmarshal(content, rec); err != nil {
+		return nil, fmt.Errorf("cannot parse state of container `%s`: %v", id, err)
+	}
+	return rec, nil
+}
+
+func (r *Runtime) save(rec *record) error {
+	content, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	statePath := filepath.Join(r.stateDir(rec.Id), stateFileName)
+	if err := ioutil.WriteFile(statePath, content, 0600); err != nil {
+		return fmt.Errorf("cannot write state `%s`: %v", statePath, err)
+	}
+	return nil
+}
+
+func (r *Runtime) status(rec *record) string {
+	if !internal.IsAlive(rec.Pid) {
+		return StatusStopped
+	}
+	if internal.Exist(filepath.Join(r.stateDir(rec.Id), execFifoName), false) {
+		return StatusCreated
+	}
+	return StatusRunning
+}
+
+// State returns the state of container `id`
+func (r *Runtime) State(id string) (*State, error) {
+	rec, err := r.load(id)
+	if err != nil {
+		return nil, err
+	}
+	state := &State{
+		OciVersion:  Version,
+		Id:          rec.Id,
+		Status:      r.status(rec),
+		Bundle:      rec.Bundle,
+		Annotations: rec.Annotations,
+	}
+	if state.Status != StatusStopped {
+		state.Pid = rec.Pid
+	}
+	return state, nil
+}
+
+// Create sets up container `id` from `bundle`, its process is started by `Start`
+func (r *Runtime) Create(id, bundle string) (*State, error) {
+	if err := validateId(id); err != nil {
+		return nil, err
+	}
+	bundle, err := filepath.Abs(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve bundle path: %v", err)
+	}
+	spec, err := LoadSpec(bundle)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := cloneFlags(spec.Linux.Namespaces)
+	if err != nil {
+		return nil, err
+	}
+	resConf, err := ConvertResources(spec.Linux.Resources)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := convertDevices(spec.Linux.Devices)
+	if err != nil {
+		return nil, err
+	}
+	// the nodes the bundle lists must be usable whatever its own rules deny, the default nodes have their rules already
+	for _, device := range devices[len(subsystems.DefaultDevices):] {
+		rule := device.DeviceRule
+		resConf.Devices = append(resConf.Devices, &rule)
+	}
+
+	stateDir := r.stateDir(id)
+	if internal.Exist(stateDir, true) {
+		return nil, fmt.Errorf("container `%s` already exists", id)
+	}
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return nil, fmt.Errorf("cannot create state dir `%s`: %v", stateDir, err)
+	}
+	created := false
+	defer func() {
+		if !created {
+			_ = os.RemoveAll(stateDir)
+		}
+	}()
+
+	fifoPath := filepath.Join(stateDir, execFifoName)
+	if err := syscall.Mkfifo(fifoPath, 0622); err != nil {
+		return nil, fmt.Errorf("cannot create exec fifo: %v", err)
+	}
+	// opening a fifo for both reading and writing does not block, init keeps it open for reading
+	// so that `start` can open it for writing as long as init is alive
+	fifo, err := os.OpenFile(fifoPath, os.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open exec fifo: %v", err)
+	}
+	defer func() {
+		_ = fifo.Close()
+	}()
+
+	readPipe, writePipe, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create new pipe: %v", err)
+	}
+	defer func() {
+		_ = writePipe.Close()
+	}()
+	self, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the location of `self`: %v", err)
+	}
+
+	initProcess := exec.Command(self, "init")
+	initProcess.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: flags,
+	}
+	// the caller hands its stdio to the container
+	initProcess.Stdin = os.Stdin
+	initProcess.Stdout = os.Stdout
+	initProcess.Stderr = os.Stderr
+	initProcess.ExtraFiles = []*os.File{readPipe, fifo}
+	initProcess.Env = spec.Process.Env
+	initProcess.Dir = spec.Root.Path
+
+	if err := initProcess.Start(); err != nil {
+		return nil, fmt.Errorf("cannot start init process: %v", err)
+	}
+	if err := readPipe.Close(); err != nil {
+		return nil, err
+	}
+	pid := initProcess.Process.Pid
+	defer func() {
+		if !created {
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+			_ = initProcess.Wait()
+		}
+	}()
+
+	cgroupPath := spec.Linux.CgroupsPath
+	if cgroupPath == "" {
+		cgroupPath = filepath.Join("oreo-box", id)
+	}
+	cgroupManager := cgroup.NewCgroupManager(cgroupPath)
+	defer func() {
+		if !created {
+			_ = cgroupManager.Destroy()
+		}
+	}()
+	if err := cgroupManager.Set(resConf); err != nil {
+		return nil, fmt.Errorf("cgroup manager `set` failed with: %v", err)
+	}
+	if err := cgroupManager.Apply(pid); err != nil {
+		return nil, fmt.Errorf("cgroup manager `apply` failed with: %v", err)
+	}
+
+	initConfig := &internal.InitConfig{
+		Args:           spec.Process.Args,
+		Devices:        devices,
+		Rootfs:         spec.Root.Path,
+		ReadonlyRoot:   spec.Root.Readonly,
+		Hostname:       spec.Hostname,
+		Cwd:            spec.Process.Cwd,
+		Uid:            spec.Process.User.UID,
+		Gid:            spec.Process.User.GID,
+		AdditionalGids: spec.Process.User.AdditionalGids,
+		WaitStart:      true,
+		Silent:         true,
+	}
+	for _, m := range spec.Mounts {
+		initConfig.Mounts = append(initConfig.Mounts, convertMount(m))
+	}
+	configBytes, err := json.Marshal(initConfig)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writePipe.Write(configBytes); err != nil {
+		return nil, fmt.Errorf("cannot send init config: %v", err)
+	}
+	if err := writePipe.Close(); err != nil {
+		return nil, err
+	}
+
+	rec := &record{
+		Id:          id,
+		Pid:         pid,
+		Bundle:      bundle,
+		CgroupPath:  cgroupPath,
+		Annotations: spec.Annotations,
+		Created:     time.Now(),
+	}
+	if err := r.save(rec); err != nil {
+		return nil, err
+	}
+	// init outlives the runtime
+	if err := initProcess.Process.Release(); err != nil {
+		return nil, err
+	}
+	created = true
+	return r.State(id)
+}
+
+// Start runs the process of a created container
+func (r *Runtime) Start(id string) error {
+	rec, err := r.load(id)
+	if err != nil {
+		return err
+	}
+	if status := r.status(rec); status != StatusCreated {
+		return fmt.Errorf("container `%s` is %s, not %s", id, status, StatusCreated)
+	}
+	fifoPath := filepath.Join(r.stateDir(id), execFifoName)
+	// without blocking, the open fails if init is gone and no longer holds the fifo for reading
+	fifo, err := os.OpenFile(fifoPath, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.ENXIO {
+		return fmt.Errorf("container `%s` failed to start", id)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot open exec fifo: %v", err)
+	}
+	defer func() {
+		_ = fifo.Close()
+	}()
+	if _, err := fifo.Write([]byte{0}); err != nil {
+		return fmt.Errorf("cannot write exec fifo: %v", err)
+	}
+	if err := os.Remove(fifoPath); err != nil {
+		return fmt.Errorf("cannot remove exec fifo: %v", err)
+	}
+	return nil
+}
+
+// Kill sends `signal` to the init process of a container
+func (r *Runtime) Kill(id string, signal syscall.Signal) error {
+	rec, err := r.load(id)
+	if err != nil {
+		return err
+	}
+	if r.status(rec) == StatusStopped {
+		return fmt.Errorf("container `%s` is not running", id)
+	}
+	if err := syscall.Kill(rec.Pid, signal); err != nil {
+		return fmt.Errorf("cannot send %v to container `%s`: %v", signal, id, err)
+	}
+	return nil
+}
+
+// Delete removes the cgroup and the state of a stopped container, `force` kills it first
+func (r *Runtime) Delete(id string, force bool) error {
+	rec, err := r.load(id)
+	if err != nil {
+		return err
+	}
+	if status := r.status(rec); status != StatusStopped {
+		// a created container has not run anything yet and can go
+		if !force && status != StatusCreated {
+			return fmt.Errorf("cannot delete container `%s` which is %s", id, status)
+		}
+		if err := syscall.Kill(rec.Pid, syscall.SIGKILL); err != nil {
+			return fmt.Errorf("cannot kill container `%s`: %v", id, err)
+		}
+		for i := 0; i < 100 && internal.IsAlive(rec.Pid); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if err := cgroup.NewCgroupManager(rec.CgroupPath).Destroy(); err != nil {
+		return fmt.Errorf("cannot remove cgroup of container `%s`: %v", id, err)
+	}
+	if err := os.RemoveAll(r.stateDir(id)); err != nil {
+		return fmt.Errorf("cannot remove state of container `%s`: %v", id, err)
+	}
+	return nil
+}
+
+// ParseSignal parses a signal given as a number, e.g. `9`, or a name with or without the `SIG` prefix
+func ParseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return syscall.Signal(n), nil
+	}
+	name := s
+	if len(name) > 3 && name[:3] == "SIG" {
+		name = name[3:]
+	}
+	if signal, ok := signals[name]; ok {
+		return signal, nil
+	}
+	return 0, fmt.Errorf("unknown signal `%s`", s)
+}
+
+var signals = map[string]syscall.Signal{
+	"ABRT":  syscall.SIGABRT,
+	"ALRM":  syscall.SIGALRM,
+	"CHLD":  syscall.SIGCHLD,
+	"CONT":  syscall.SIGCONT,
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"KILL":  syscall.SIGKILL,
+	"PIPE":  syscall.SIGPIPE,
+	"QUIT":  syscall.SIGQUIT,
+	"STOP":  syscall.SIGSTOP,
+	"TERM":  syscall.SIGTERM,
+	"TSTP":  syscall.SIGTSTP,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"WINCH": syscall.SIGWINCH,
+}
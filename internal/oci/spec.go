@@ -0,0 +1,152 @@
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// the subset of the OCI runtime spec (https://github.com/opencontainers/runtime-spec) understood by oreo-box,
+// fields which are not listed here are ignored
+
+const (
+	SpecFileName = "config.json"
+	Version      = "1.0.2"
+)
+
+type Spec struct {
+	OciVersion  string            `json:"ociVersion"`
+	Process     *Process          `json:"process"`
+	Root        *Root             `json:"root"`
+	Hostname    string            `json:"hostname,omitempty"`
+	Mounts      []Mount           `json:"mounts,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Linux       *Linux            `json:"linux"`
+}
+
+type Process struct {
+	Terminal bool     `json:"terminal,omitempty"`
+	User     User     `json:"user"`
+	Args     []string `json:"args"`
+	Env      []string `json:"env,omitempty"`
+	Cwd      string   `json:"cwd"`
+}
+
+type User struct {
+	UID            uint32   `json:"uid"`
+	GID            uint32   `json:"gid"`
+	AdditionalGids []uint32 `json:"additionalGids,omitempty"`
+}
+
+type Root struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly,omitempty"`
+}
+
+type Mount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+type Linux struct {
+	Devices     []LinuxDevice    `json:"devices,omitempty"`
+	CgroupsPath string           `json:"cgroupsPath,omitempty"`
+	Resources   *LinuxResources  `json:"resources,omitempty"`
+	Namespaces  []LinuxNamespace `json:"namespaces,omitempty"`
+}
+
+type LinuxNamespace struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+}
+
+type LinuxDevice struct {
+	Path     string  `json:"path"`
+	Type     string  `json:"type"`
+	Major    int64   `json:"major"`
+	Minor    int64   `json:"minor"`
+	FileMode *uint32 `json:"fileMode,omitempty"`
+	UID      *uint32 `json:"uid,omitempty"`
+	GID      *uint32 `json:"gid,omitempty"`
+}
+
+type LinuxResources struct {
+	Devices []LinuxDeviceCgroup `json:"devices,omitempty"`
+	Memory  *LinuxMemory        `json:"memory,omitempty"`
+	CPU     *LinuxCPU           `json:"cpu,omitempty"`
+	Pids    *LinuxPids          `json:"pids,omitempty"`
+	BlockIO *LinuxBlockIO       `json:"blockIO,omitempty"`
+}
+
+type LinuxDeviceCgroup struct {
+	Allow  bool   `json:"allow"`
+	Type   string `json:"type,omitempty"`
+	Major  *int64 `json:"major,omitempty"`
+	Minor  *int64 `json:"minor,omitempty"`
+	Access string `json:"access,omitempty"`
+}
+
+type LinuxMemory struct {
+	Limit       *int64 `json:"limit,omitempty"`
+	Reservation *int64 `json:"reservation,omitempty"`
+	Swap        *int64 `json:"swap,omitempty"`
+}
+
+type LinuxCPU struct {
+	Shares *uint64 `json:"shares,omitempty"`
+	Quota  *int64  `json:"quota,omitempty"`
+	Period *uint64 `json:"period,omitempty"`
+	Cpus   string  `json:"cpus,omitempty"`
+	Mems   string  `json:"mems,omitempty"`
+}
+
+type LinuxPids struct {
+	Limit int64 `json:"limit"`
+}
+
+type LinuxBlockIO struct {
+	Weight                  *uint16               `json:"weight,omitempty"`
+	ThrottleReadBpsDevice   []LinuxThrottleDevice `json:"throttleReadBpsDevice,omitempty"`
+	ThrottleWriteBpsDevice  []LinuxThrottleDevice `json:"throttleWriteBpsDevice,omitempty"`
+	ThrottleReadIOPSDevice  []LinuxThrottleDevice `json:"throttleReadIOPSDevice,omitempty"`
+	ThrottleWriteIOPSDevice []LinuxThrottleDevice `json:"throttleWriteIOPSDevice,omitempty"`
+}
+
+type LinuxThrottleDevice struct {
+	Major int64  `json:"major"`
+	Minor int64  `json:"minor"`
+	Rate  uint64 `json:"rate"`
+}
+
+// LoadSpec reads `config.json` of a bundle, resolving the root path against the bundle
+func LoadSpec(bundle string) (*Spec, error) {
+	specPath := filepath.Join(bundle, SpecFileName)
+	content, err := ioutil.ReadFile(specPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read bundle config `%s`: %v", specPath, err)
+	}
+	spec := &Spec{}
+	if err := json.Unmarshal(content, spec); err != nil {
+		return nil, fmt.Errorf("cannot parse bundle config `%s`: %v", specPath, err)
+	}
+
+	if spec.Process == nil || len(spec.Process.Args) == 0 {
+		return nil, fmt.Errorf("bundle config has no process args")
+	}
+	if spec.Process.Terminal {
+		return nil, fmt.Errorf("process.terminal is not supported")
+	}
+	if spec.Root == nil || spec.Root.Path == "" {
+		return nil, fmt.Errorf("bundle config has no root path")
+	}
+	if !filepath.IsAbs(spec.Root.Path) {
+		spec.Root.Path = filepath.Join(bundle, spec.Root.Path)
+	}
+	if spec.Linux == nil {
+		spec.Linux = &Linux{}
+	}
+	return spec, nil
+}
@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// clock ticks per second used by /proc/<pid>/stat, fixed to 100 on all architectures we run on
+const clockTicks = 100
+
+// ProcessInfo describes a process as reported by /proc
+type ProcessInfo struct {
+	Pid        int
+	NsPid      int // pid inside the innermost pid namespace of the process
+	Uid        int
+	User       string
+	CpuPercent float64
+	RssBytes   uint64
+	Command    string
+}
+
+func GetProcessInfo(pid int) (*ProcessInfo, error) {
+	info := &ProcessInfo{Pid: pid, NsPid: pid}
+	if err := readProcessStatus(info); err != nil {
+		return nil, err
+	}
+
+	cpuSeconds, startSeconds, err := readProcessTimes(pid)
+	if err != nil {
+		return nil, err
+	}
+	uptime, err := readUptime()
+	if err != nil {
+		return nil, err
+	}
+	// average over the lifetime of the process, the same way `ps` computes it
+	if elapsed := uptime - startSeconds; elapsed > 0 {
+		info.CpuPercent = cpuSeconds / elapsed * 100
+	}
+
+	cmdlinePath := fmt.Sprintf("/proc/%d/cmdline", pid)
+	cmdline, err := ioutil.ReadFile(cmdlinePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read file %s : %v", cmdlinePath, err)
+	}
+	if command := strings.TrimSpace(strings.Replace(string(cmdline), "\u0000", " ", -1)); command != "" {
+		info.Command = command
+	} else {
+		// zombies have no command line, show the name from /proc/<pid>/status instead
+		info.Command = "[" + info.Command + "]"
+	}
+
+	info.User = lookupUserName(pid, info.Uid)
+	return info, nil
+}
+
+// readProcessStatus fills pids, uid and memory usage from /proc/<pid>/status
+func readProcessStatus(info *ProcessInfo) error {
+	statusPath := fmt.Sprintf("/proc/%d/status", info.Pid)
+	f, err := os.Open(statusPath)
+	if err != nil {
+		return fmt.Errorf("cannot open file %s : %v", statusPath, err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			panic(err)
+		}
+	}()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "NSpid:":
+			// the last one is the pid in the namespace the process lives in
+			if nsPid, err := strconv.Atoi(fields[len(fields)-1]); err == nil {
+				info.NsPid = nsPid
+			}
+		case "Uid:":
+			if uid, err := strconv.Atoi(fields[1]); err == nil {
+				info.Uid = uid
+			}
+		case "VmRSS:":
+			if kb, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+				info.RssBytes = kb * 1024
+			}
+		case "Name:":
+			info.Command = fields[1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("cannot read file %s : %v", statusPath, err)
+	}
+	return nil
+}
+
+// readProcessTimes returns consumed cpu seconds and start time in seconds after boot
+func readProcessTimes(pid int) (float64, float64, error) {
+	statPath := fmt.Sprintf("/proc/%d/stat", pid)
+	content, err := ioutil.ReadFile(statPath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot read file %s : %v", statPath, err)
+	}
+	// the command in the second field may contain spaces, skip past its closing parenthesis
+	stat := string(content)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	// fields now start at `state`, the third field in proc(5)
+	if len(fields) < 20 {
+		return 0, 0, fmt.Errorf("malformed file %s", statPath)
+	}
+	utime, _ := strconv.ParseFloat(fields[11], 64)
+	stime, _ := strconv.ParseFloat(fields[12], 64)
+	start, _ := strconv.ParseFloat(fields[19], 64)
+	return (utime + stime) / clockTicks, start / clockTicks, nil
+}
+
+func readUptime() (float64, error) {
+	content, err := ioutil.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, fmt.Errorf("cannot read file /proc/uptime : %v", err)
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("malformed file /proc/uptime")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// lookupUserName resolves `uid` with the passwd file of the process's own root, falling back to the number
+func lookupUserName(pid, uid int) string {
+	passwdPath := fmt.Sprintf("/proc/%d/root/etc/passwd", pid)
+	content, err := ioutil.ReadFile(passwdPath)
+	if err == nil {
+		for _, line := range strings.Split(string(content), "\n") {
+			fields := strings.Split(line, ":")
+			if len(fields) > 2 && fields[2] == strconv.Itoa(uid) {
+				return fields[0]
+			}
+		}
+	}
+	return strconv.Itoa(uid)
+}
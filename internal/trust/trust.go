@@ -0,0 +1,286 @@
+package trust
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/yqszxx/oreo-box/config"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// image signatures are checked against the built-in key and the armored public keys in `config.TrustPath`,
+// each stored as `<fingerprint>.asc`
+
+// Key is a trusted public key
+type Key struct {
+	Fingerprint string    `json:"fingerprint"`
+	KeyId       string    `json:"keyId"`
+	Identities  []string  `json:"identities"`
+	Created     time.Time `json:"created"`
+	// Expires is zero for keys which never expire
+	Expires time.Time `json:"expires,omitempty"`
+	Revoked bool      `json:"revoked"`
+	// Builtin is the key compiled in as `config.PublicKey`, which cannot be removed
+	Builtin bool `json:"builtin"`
+}
+
+// NotFoundError is returned when no trusted key matches
+type NotFoundError struct {
+	Id string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("no trusted key `%s`", e.Id)
+}
+
+func fingerprint(entity *openpgp.Entity) string {
+	return fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)
+}
+
+func describe(entity *openpgp.Entity, builtin bool) *Key {
+	key := &Key{
+		Fingerprint: fingerprint(entity),
+		KeyId:       entity.PrimaryKey.KeyIdString(),
+		Created:     entity.PrimaryKey.CreationTime,
+		Revoked:     len(entity.Revocations) > 0,
+		Builtin:     builtin,
+	}
+	for name, identity := range entity.Identities {
+		key.Identities = append(key.Identities, name)
+		if sig := identity.SelfSignature; sig != nil && sig.KeyLifetimeSecs != nil && *sig.KeyLifetimeSecs != 0 {
+			key.Expires = entity.PrimaryKey.CreationTime.Add(time.Duration(*sig.KeyLifetimeSecs) * time.Second)
+		}
+	}
+	sort.Strings(key.Identities)
+	return key
+}
+
+// readKeys reads the trusted keys, the built-in one first
+func readKeys() (openpgp.EntityList, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(config.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read built-in public key: %v", err)
+	}
+	keyFiles, err := filepath.Glob(path.Join(config.TrustPath, "*.asc"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keyFiles)
+	for _, keyFile := range keyFiles {
+		content, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read trusted key `%s`: %v", keyFile, err)
+		}
+		entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse trusted key `%s`: %v", keyFile, err)
+		}
+		keyring = append(keyring, entities...)
+	}
+	return keyring, nil
+}
+
+// List returns the trusted keys
+func List() ([]*Key, error) {
+	keyring, err := readKeys()
+	if err != nil {
+		return nil, err
+	}
+	var keys []*Key
+	for i, entity := range keyring {
+		keys = append(keys, describe(entity, i == 0))
+	}
+	return keys, nil
+}
+
+// Add trusts the armored public keys read from `r`, refusing expired and revoked ones. A revoked key which is
+// already trusted is stored over it, so that its signatures are rejected from then on
+func Add(r io.Reader) ([]*Key, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read public key: %v", err)
+	}
+	trusted, err := readKeys()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(config.TrustPath, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create trust dir `%s`: %v", config.TrustPath, err)
+	}
+	now := time.Now()
+	var keys []*Key
+	for _, entity := range entities {
+		copies := copiesOf(trusted, entity)
+		revokesTrusted := len(entity.Revocations) > 0 && len(copies) > 0
+		if err := checkEntity(entity, now); err != nil && !revokesTrusted {
+			return keys, err
+		}
+		// a revocation is never undone by the key it revoked
+		for _, trustedCopy := range copies {
+			if len(trustedCopy.Revocations) > 0 && len(entity.Revocations) == 0 {
+				return keys, fmt.Errorf("key %s is revoked", fingerprint(entity))
+			}
+		}
+		keyFile, err := os.Create(path.Join(config.TrustPath, fingerprint(entity)+".asc"))
+		if err != nil {
+			return keys, fmt.Errorf("cannot store key %s: %v", fingerprint(entity), err)
+		}
+		w, err := armor.Encode(keyFile, openpgp.PublicKeyType, nil)
+		if err == nil {
+			err = serialize(w, entity)
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		if closeErr := keyFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return keys, fmt.Errorf("cannot store key %s: %v", fingerprint(entity), err)
+		}
+		keys = append(keys, describe(entity, false))
+	}
+	return keys, nil
+}
+
+// Remove stops trusting the key whose fingerprint or key id is `id`
+func Remove(id string) (*Key, error) {
+	keys, err := List()
+	if err != nil {
+		return nil, err
+	}
+	id = strings.ToUpper(strings.TrimPrefix(id, "0x"))
+	for _, key := range keys {
+		if key.Fingerprint != id && key.KeyId != id {
+			continue
+		}
+		if key.Builtin {
+			return nil, fmt.Errorf("the built-in key %s cannot be removed", key.Fingerprint)
+		}
+		if err := os.Remove(path.Join(config.TrustPath, key.Fingerprint+".asc")); err != nil {
+			return nil, fmt.Errorf("cannot remove key %s: %v", key.Fingerprint, err)
+		}
+		return key, nil
+	}
+	return nil, &NotFoundError{Id: id}
+}
+
+// Verify checks an armored detached signature of `signed` and returns the key which made it,
+// signatures by expired or revoked keys are rejected
+func Verify(signed, signature io.Reader) (*Key, error) {
+	keyring, err := readKeys()
+	if err != nil {
+		return nil, err
+	}
+	signatureBytes, err := ioutil.ReadAll(signature)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read signature: %v", err)
+	}
+	issuer, err := issuerKeyId(signatureBytes)
+	if err != nil {
+		return nil, err
+	}
+	keys := keyring.KeysById(issuer)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("image is signed by untrusted key %016X", issuer)
+	}
+	key := keys[0]
+	now := time.Now()
+	// the built-in key is revoked by a copy in the trust dir
+	for _, entity := range copiesOf(keyring, key.Entity) {
+		if err := checkEntity(entity, now); err != nil {
+			return nil, err
+		}
+	}
+	if key.PublicKey != key.Entity.PrimaryKey && key.SelfSignature != nil {
+		if key.SelfSignature.SigType == packet.SigTypeSubkeyRevocation || key.SelfSignature.RevocationReason != nil {
+			return nil, fmt.Errorf("signing subkey %016X is revoked", issuer)
+		}
+		if key.SelfSignature.KeyExpired(now) {
+			return nil, fmt.Errorf("signing subkey %016X has expired", issuer)
+		}
+	}
+
+	if _, err := openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{key.Entity}, signed, bytes.NewReader(signatureBytes)); err != nil {
+		return nil, fmt.Errorf("cannot verify image signature: %v", err)
+	}
+	return describe(key.Entity, fingerprint(key.Entity) == fingerprint(keyring[0])), nil
+}
+
+// serialize writes `entity` as `Serialize` does, along with its revocations which `Serialize` leaves out
+func serialize(w io.Writer, entity *openpgp.Entity) error {
+	primaryKey := &bytes.Buffer{}
+	if err := entity.PrimaryKey.Serialize(primaryKey); err != nil {
+		return err
+	}
+	serialized := &bytes.Buffer{}
+	if err := entity.Serialize(serialized); err != nil {
+		return err
+	}
+	// revocations follow the primary key
+	if _, err := w.Write(serialized.Next(primaryKey.Len())); err != nil {
+		return err
+	}
+	for _, revocation := range entity.Revocations {
+		if err := revocation.Serialize(w); err != nil {
+			return err
+		}
+	}
+	_, err := serialized.WriteTo(w)
+	return err
+}
+
+// copiesOf returns the keys of `keyring` with the fingerprint of `entity`
+func copiesOf(keyring openpgp.EntityList, entity *openpgp.Entity) openpgp.EntityList {
+	var copies openpgp.EntityList
+	for _, candidate := range keyring {
+		if fingerprint(candidate) == fingerprint(entity) {
+			copies = append(copies, candidate)
+		}
+	}
+	return copies
+}
+
+// checkEntity rejects revoked and expired keys
+func checkEntity(entity *openpgp.Entity, now time.Time) error {
+	if len(entity.Revocations) > 0 {
+		return fmt.Errorf("key %s is revoked", fingerprint(entity))
+	}
+	for _, identity := range entity.Identities {
+		if identity.SelfSignature != nil && identity.SelfSignature.KeyExpired(now) {
+			return fmt.Errorf("key %s has expired", fingerprint(entity))
+		}
+	}
+	return nil
+}
+
+func issuerKeyId(armoredSignature []byte) (uint64, error) {
+	block, err := armor.Decode(bytes.NewReader(armoredSignature))
+	if err != nil {
+		return 0, fmt.Errorf("cannot decode signature: %v", err)
+	}
+	p, err := packet.Read(block.Body)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read signature: %v", err)
+	}
+	switch sig := p.(type) {
+	case *packet.Signature:
+		if sig.IssuerKeyId == nil {
+			return 0, fmt.Errorf("signature does not name its key")
+		}
+		return *sig.IssuerKeyId, nil
+	case *packet.SignatureV3:
+		return sig.IssuerKeyId, nil
+	default:
+		return 0, fmt.Errorf("not a signature")
+	}
+}
@@ -1,10 +1,12 @@
 package internal
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/yqszxx/oreo-box/config"
 	"io/ioutil"
+	"log"
 	"os"
 	"path"
 	"strings"
@@ -27,7 +29,13 @@ func IsAlive(pid int) bool {
 	if err := process.Signal(syscall.Signal(0)); err != nil {
 		return false
 	}
-	return true
+	// a zombie still takes signals until its parent reaps it
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
 }
 
 func GetBoxPidByName(boxName string) (string, error) {
@@ -67,3 +75,57 @@ func GetBoxInfoByName(boxName string) (*BoxInfo, error) {
 	}
 	return &BoxInfo, nil
 }
+
+func GetAllBoxInfos() ([]*BoxInfo, error) {
+	files, err := ioutil.ReadDir(config.BoxDataPath)
+	if os.IsNotExist(err) {
+		// no box has ever been created
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read dir %s : %v", config.BoxDataPath, err)
+	}
+
+	var boxes []*BoxInfo
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		boxInfo, err := GetBoxInfoByName(file.Name())
+		if err != nil {
+			// the info of a box being created is not written yet, that of a crashed one may be missing or half written
+			log.Printf("Skipping box `%s`: %v", file.Name(), err)
+			continue
+		}
+		boxes = append(boxes, boxInfo)
+	}
+	return boxes, nil
+}
+
+func WriteBoxInfo(boxInfo *BoxInfo) error {
+	contentBytes, err := json.Marshal(boxInfo)
+	if err != nil {
+		return fmt.Errorf("fail to serilize box info for `%s`: %v", boxInfo.Name, err)
+	}
+	configFilePath := path.Join(config.BoxDataPath, boxInfo.Name, config.InfoFileName)
+	// the info is replaced at once so that readers never see it half written
+	file, err := ioutil.TempFile(path.Dir(configFilePath), "."+config.InfoFileName+"-")
+	if err != nil {
+		return fmt.Errorf("fail to write data to file `%s`: %v", configFilePath, err)
+	}
+	_, err = file.Write(contentBytes)
+	if err == nil {
+		err = file.Chmod(0644)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), configFilePath)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return fmt.Errorf("fail to write data to file `%s`: %v", configFilePath, err)
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 )
 
 const usage = `A lightweight yet secure container runtime.`
@@ -31,7 +32,16 @@ func main() {
 	cli.OsExiter = func(code int) {}
 	cli.ErrWriter = ioutil.Discard
 
-	if err := app.Run(os.Args); err != nil {
+	args := os.Args
+	// installed as `oreo-boxd` or `oreo-box-oci`, e.g. via a symlink, the binary is the daemon or the OCI runtime
+	switch filepath.Base(args[0]) {
+	case "oreo-boxd":
+		args = append([]string{args[0], "daemon"}, args[1:]...)
+	case "oreo-box-oci":
+		args = append([]string{args[0], "oci"}, args[1:]...)
+	}
+
+	if err := app.Run(args); err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
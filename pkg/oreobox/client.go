@@ -0,0 +1,330 @@
+package oreobox
+
+import (
+	"fmt"
+	"github.com/yqszxx/oreo-box/config"
+	"github.com/yqszxx/oreo-box/internal/api"
+	"github.com/yqszxx/oreo-box/internal/box"
+	"github.com/yqszxx/oreo-box/internal/image"
+	"github.com/yqszxx/oreo-box/internal/network"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Client manages boxes, images and networks, either through the daemon or in the calling process
+type Client struct {
+	// daemon is nil when running in-process
+	daemon *api.Client
+}
+
+// New returns a client of the daemon listening on `config.DaemonSocketPath`, or `$OB_SOCKET` if set,
+// falling back to an in-process client when no daemon is reachable
+func New() *Client {
+	socketPath := os.Getenv(config.EnvDaemonSocket)
+	if socketPath == "" {
+		socketPath = config.DaemonSocketPath
+	}
+	if _, err := os.Stat(socketPath); err != nil {
+		return NewLocal()
+	}
+	client, err := NewDaemon(socketPath)
+	if err != nil {
+		log.Printf("daemon at `%s` is not usable, running in-process: %v", socketPath, err)
+		return NewLocal()
+	}
+	return client
+}
+
+// NewDaemon returns a client of the daemon listening on `socketPath`
+func NewDaemon(socketPath string) (*Client, error) {
+	daemon := api.NewClient(socketPath)
+	if err := daemon.Ping(); err != nil {
+		return nil, err
+	}
+	return &Client{daemon: daemon}, nil
+}
+
+// NewLocal returns a client which runs every operation in the calling process, which must be root
+func NewLocal() *Client {
+	return &Client{}
+}
+
+// Remote tells whether the client goes through the daemon
+func (c *Client) Remote() bool {
+	return c.daemon != nil
+}
+
+// imageError turns the 404 of the daemon into the error returned in-process
+func imageError(name string, err error) error {
+	if apiErr, ok := err.(*api.Error); ok && apiErr.StatusCode == http.StatusNotFound {
+		return &ImageNotFoundError{Name: name}
+	}
+	return err
+}
+
+// boxError turns the 404 of the daemon into the error returned in-process
+func boxError(name string, err error) error {
+	if apiErr, ok := err.(*api.Error); ok && apiErr.StatusCode == http.StatusNotFound {
+		return &NotFoundError{Name: name}
+	}
+	return err
+}
+
+// Run creates and starts a box, an interactive box is attached to the streams of `spec` and waited for,
+// in which case the returned box is nil when going through the daemon
+func (c *Client) Run(spec *BoxSpec) (*Box, error) {
+	if c.daemon == nil {
+		return box.Run(spec)
+	}
+	// the daemon resolves paths against its own working directory
+	remoteSpec := *spec
+	volumePaths := strings.Split(spec.Volume, ":")
+	if len(volumePaths) == 2 && volumePaths[0] != "" {
+		hostPath, err := filepath.Abs(volumePaths[0])
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve volume `%s`: %v", spec.Volume, err)
+		}
+		remoteSpec.Volume = hostPath + ":" + volumePaths[1]
+	}
+	return c.daemon.RunBox(&remoteSpec)
+}
+
+// List returns all boxes
+func (c *Client) List() ([]*Box, error) {
+	if c.daemon == nil {
+		return box.List()
+	}
+	return c.daemon.ListBoxes()
+}
+
+// Inspect returns the details of a box
+func (c *Client) Inspect(name string) (*BoxDetail, error) {
+	if c.daemon == nil {
+		return box.Inspect(name)
+	}
+	detail, err := c.daemon.InspectBox(name)
+	return detail, boxError(name, err)
+}
+
+// Start starts a detached box again once it is stopped or has exited
+func (c *Client) Start(name string) (*Box, error) {
+	if c.daemon == nil {
+		return box.Start(name)
+	}
+	boxInfo, err := c.daemon.StartBox(name)
+	return boxInfo, boxError(name, err)
+}
+
+// Stop kills a box, its workspace is kept until `Remove`
+func (c *Client) Stop(name string) error {
+	if c.daemon == nil {
+		return box.Stop(name)
+	}
+	return boxError(name, c.daemon.StopBox(name))
+}
+
+// Remove deletes a box which is not running
+func (c *Client) Remove(name string) error {
+	if c.daemon == nil {
+		return box.Remove(name)
+	}
+	return boxError(name, c.daemon.RemoveBox(name))
+}
+
+// Update merges the non-zero fields of `update` into the resource limits of a running box
+func (c *Client) Update(name string, update *ResourceConfig) error {
+	if c.daemon == nil {
+		return box.Update(name, update)
+	}
+	return boxError(name, c.daemon.UpdateBox(name, update))
+}
+
+// Logs copies the output of a detached box to `w`
+func (c *Client) Logs(name string, w io.Writer) error {
+	if c.daemon != nil {
+		return boxError(name, c.daemon.BoxLogs(name, w))
+	}
+	logs, err := box.Logs(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := logs.Close(); err != nil {
+			panic(err)
+		}
+	}()
+	if _, err := io.Copy(w, logs); err != nil {
+		return fmt.Errorf("cannot read logs of box `%s`: %v", name, err)
+	}
+	return nil
+}
+
+// Exec runs a command inside a running box and waits for it, the daemon merges stderr into stdout
+func (c *Client) Exec(name string, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if c.daemon == nil {
+		return box.Exec(name, args, stdin, stdout, stderr)
+	}
+	return boxError(name, c.daemon.ExecBox(name, args, stdin, stdout))
+}
+
+// Commit creates image `imageName` from the image of a box and the changes made in the box
+func (c *Client) Commit(name, imageName string, options *CommitOptions) (*ImageMetadata, error) {
+	if options == nil {
+		options = &CommitOptions{}
+	}
+	if c.daemon == nil {
+		return box.Commit(name, imageName, options)
+	}
+	remoteOptions := *options
+	if remoteOptions.SignKeyPath != "" {
+		absPath, err := filepath.Abs(remoteOptions.SignKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve signing key path: %v", err)
+		}
+		remoteOptions.SignKeyPath = absPath
+	}
+	metadata, err := c.daemon.CommitBox(name, imageName, &remoteOptions)
+	return metadata, boxError(name, err)
+}
+
+// Export writes the merged filesystem of a box to `w` as a tarball
+func (c *Client) Export(name string, w io.Writer) error {
+	if c.daemon == nil {
+		return box.Export(name, w)
+	}
+	return boxError(name, c.daemon.ExportBox(name, w))
+}
+
+// Images describes all imported images, or those `references` refer to by name, tag or digest
+func (c *Client) Images(references ...string) ([]*Image, error) {
+	if c.daemon == nil {
+		return image.List(references...)
+	}
+	names, err := c.daemon.ListImages(references...)
+	if err != nil {
+		return nil, err
+	}
+	var images []*Image
+	for _, name := range names {
+		detail, err := c.daemon.InspectImage(name)
+		if apiErr, ok := err.(*api.Error); ok && apiErr.StatusCode == http.StatusNotFound {
+			// removed meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, detail)
+	}
+	return images, nil
+}
+
+// ImportImage verifies and imports the signed image file at `path`
+func (c *Client) ImportImage(name, path string, options *ImportOptions) error {
+	if options == nil {
+		options = &ImportOptions{}
+	}
+	if c.daemon == nil {
+		return image.Import(name, path, options)
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("cannot resolve image file path: %v", err)
+	}
+	return c.daemon.ImportImage(name, absPath, options)
+}
+
+// RemoveImage removes an image, which fails with an `ImageInUseError` if boxes use it unless forced
+func (c *Client) RemoveImage(name string, force bool) error {
+	if c.daemon == nil {
+		return image.Remove(name, force)
+	}
+	return imageError(name, c.daemon.RemoveImage(name, force))
+}
+
+// InspectImage describes the image `reference` refers to by name, tag or digest
+func (c *Client) InspectImage(reference string) (*ImageDetail, error) {
+	if c.daemon == nil {
+		return image.Inspect(reference)
+	}
+	detail, err := c.daemon.InspectImage(reference)
+	return detail, imageError(reference, err)
+}
+
+// ImageHistory returns how a layered image was built, latest step first
+func (c *Client) ImageHistory(reference string) ([]*ImageHistoryEntry, error) {
+	if c.daemon == nil {
+		return image.LayerHistory(reference)
+	}
+	entries, err := c.daemon.ImageHistory(reference)
+	return entries, imageError(reference, err)
+}
+
+// TagImage adds `tag`, as `name[:tag]`, to the image `reference` refers to, a tag of another image moves only if forced
+func (c *Client) TagImage(reference, tag string, force bool) error {
+	if c.daemon == nil {
+		return image.Tag(reference, tag, force)
+	}
+	return imageError(reference, c.daemon.TagImage(reference, tag, force))
+}
+
+// SaveImage writes an image to `w` in the signed format `ImportImage` accepts, signed with the armored
+// private key at `signKeyPath` if given, which images other than those imported in that format require
+func (c *Client) SaveImage(name string, w io.Writer, signKeyPath string) error {
+	if c.daemon == nil {
+		return image.Save(name, w, signKeyPath)
+	}
+	if signKeyPath != "" {
+		absPath, err := filepath.Abs(signKeyPath)
+		if err != nil {
+			return fmt.Errorf("cannot resolve signing key path: %v", err)
+		}
+		signKeyPath = absPath
+	}
+	return imageError(name, c.daemon.SaveImage(name, signKeyPath, w))
+}
+
+// PruneImages removes the image layers no image references
+func (c *Client) PruneImages() (*PruneReport, error) {
+	if c.daemon == nil {
+		return image.Prune()
+	}
+	return c.daemon.PruneImages()
+}
+
+// Networks returns all box networks
+func (c *Client) Networks() ([]*Network, error) {
+	if c.daemon == nil {
+		if err := network.Init(); err != nil {
+			return nil, fmt.Errorf("cannot init network controller: %v", err)
+		}
+		return network.Networks(), nil
+	}
+	return c.daemon.ListNetworks()
+}
+
+// CreateNetwork creates a network named `name` with the given driver, e.g. `bridge`, on `subnet` in CIDR notation
+func (c *Client) CreateNetwork(name, driver, subnet string) error {
+	if c.daemon == nil {
+		if err := network.Init(); err != nil {
+			return fmt.Errorf("cannot init network controller: %v", err)
+		}
+		return network.CreateNetwork(driver, subnet, name)
+	}
+	return c.daemon.CreateNetwork(name, driver, subnet)
+}
+
+// RemoveNetwork removes a box network
+func (c *Client) RemoveNetwork(name string) error {
+	if c.daemon == nil {
+		if err := network.Init(); err != nil {
+			return fmt.Errorf("cannot init network controller: %v", err)
+		}
+		return network.DeleteNetwork(name)
+	}
+	return c.daemon.RemoveNetwork(name)
+}
@@ -0,0 +1,11 @@
+// Package oreobox manages boxes, images and networks from Go programs.
+//
+//	client := oreobox.New()
+//	b, err := client.Run(&oreobox.BoxSpec{
+//		Image:     "busybox",
+//		Args:      []string{"top"},
+//		Resources: &oreobox.ResourceConfig{MemoryLimit: 64 << 20},
+//	})
+//
+// Operations go through the daemon when it is up, otherwise they run in the calling process, which must be root.
+package oreobox
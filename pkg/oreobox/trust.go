@@ -0,0 +1,28 @@
+package oreobox
+
+import (
+	"github.com/yqszxx/oreo-box/internal/trust"
+	"io"
+)
+
+// the trust store is a directory on the host which every verification reads, the daemon's included,
+// so it is managed in-process
+
+// TrustedKey is a public key whose signatures are accepted on image import
+type TrustedKey = trust.Key
+
+// TrustedKeys returns the trusted keys, the built-in one first
+func TrustedKeys() ([]*TrustedKey, error) {
+	return trust.List()
+}
+
+// TrustKeys trusts the armored public keys read from `r`, expired and revoked keys are refused
+// unless they revoke a trusted key
+func TrustKeys(r io.Reader) ([]*TrustedKey, error) {
+	return trust.Add(r)
+}
+
+// DistrustKey stops trusting the key with fingerprint or key id `id`
+func DistrustKey(id string) (*TrustedKey, error) {
+	return trust.Remove(id)
+}
@@ -0,0 +1,94 @@
+package oreobox
+
+import (
+	"github.com/yqszxx/oreo-box/internal"
+	"github.com/yqszxx/oreo-box/internal/box"
+	"github.com/yqszxx/oreo-box/internal/cgroup/subsystems"
+	"github.com/yqszxx/oreo-box/internal/image"
+	"github.com/yqszxx/oreo-box/internal/network"
+)
+
+// the types below are shared with the runtime, so values pass through without conversion
+
+// BoxSpec describes a box to be created by `Client.Run`
+type BoxSpec = box.Spec
+
+// Box is the recorded state of a box
+type Box = internal.BoxInfo
+
+// BoxDetail is a box together with its live state
+type BoxDetail = box.Detail
+
+// ResourceConfig holds the cgroup limits of a box, zero values are left unset
+type ResourceConfig = subsystems.ResourceConfig
+
+// Device is a device node created inside a box
+type Device = subsystems.Device
+
+// DeviceRule is an entry of the device access list of a box
+type DeviceRule = subsystems.DeviceRule
+
+// ThrottleDevice is a per-device blkio limit
+type ThrottleDevice = subsystems.ThrottleDevice
+
+// Network is a box network
+type Network = network.Network
+
+// BoxFilter matches boxes by `id`, `name`, `status` or `ancestor`, the image they are created from
+type BoxFilter = box.Filter
+
+// ParseBoxFilter parses `key=value` filters
+func ParseBoxFilter(filters []string) (BoxFilter, error) {
+	return box.ParseFilter(filters)
+}
+
+// ImageMetadata describes an image with layers
+type ImageMetadata = image.Metadata
+
+// ImageDetail describes an image, its tags and the boxes using it
+type ImageDetail = image.Detail
+
+// ImageHistoryEntry is a step of building an image
+type ImageHistoryEntry = image.HistoryEntry
+
+// ImportOptions tune `Client.ImportImage`
+type ImportOptions = image.ImportOptions
+
+// CommitOptions tune `Client.Commit`
+type CommitOptions = image.CommitOptions
+
+// PruneReport lists the image layers removed by `Client.PruneImages`
+type PruneReport = image.PruneReport
+
+// Image describes an imported image
+type Image = image.Detail
+
+// NotFoundError is returned when the named box does not exist
+type NotFoundError = box.NotFoundError
+
+// ImageNotFoundError is returned when the named image does not exist
+type ImageNotFoundError = image.NotFoundError
+
+// ImageInUseError is returned when removing an image which boxes are created from
+type ImageInUseError = image.InUseError
+
+const (
+	Running = internal.Running
+	Stopped = internal.Stopped
+	Exited  = internal.Exited
+)
+
+// ParseDevice parses a device mapping like `/dev/sdc[:/dev/xvdc[:rwm]]` against the device node on the host
+func ParseDevice(s string) (*Device, error) {
+	return subsystems.ParseDevice(s)
+}
+
+// ParseDeviceRule parses a device access rule like `c 1:3 rwm`
+func ParseDeviceRule(s string) (*DeviceRule, error) {
+	return subsystems.ParseDeviceRule(s)
+}
+
+// ParseBytes parses a size like `512m` or `1g` into bytes
+func ParseBytes(s string) (int64, error) {
+	return subsystems.ParseBytes(s)
+}